@@ -1,71 +1,90 @@
 package cc
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
-type applier func(cfg *config.CloudConfig) error
+type applier func(t *target.Target, cfg *config.CloudConfig) error
+
+// step is an applier along with the name it is reported as
+type step struct {
+	name  string
+	apply applier
+}
 
-func runApplies(cfg *config.CloudConfig, appliers ...applier) error {
+func runApplies(t *target.Target, cfg *config.CloudConfig, phase string, steps ...step) (*Report, error) {
 	var errors []error
 
-	for _, a := range appliers {
-		err := a(cfg)
-		if err != nil {
-			errors = append(errors, err)
+	report := &Report{
+		Phase:   phase,
+		DryRun:  t.DryRun,
+		Started: time.Now(),
+	}
+	if !t.IsLive() {
+		report.Root = t.Root
+	}
+
+	for _, s := range steps {
+		before := len(t.Changes())
+		start := time.Now()
+		err := s.apply(t, cfg)
+		result := Result{
+			Name:     s.name,
+			Duration: time.Since(start),
+			Changes:  t.Changes()[before:],
+		}
+		result.Changed = len(result.Changes) > 0
+
+		if skipped, ok := err.(skipError); ok {
+			result.Skipped = true
+			result.Message = skipped.reason
+		} else if noted, ok := err.(noteError); ok {
+			result.Message = noted.note
+			if changes := summary(result.Changes); changes != "" {
+				result.Message += "; " + changes
+			}
+		} else if err != nil {
+			result.Failed = true
+			result.Message = err.Error()
+			errors = append(errors, fmt.Errorf("%s: %v", s.name, err))
+		} else {
+			result.Message = summary(result.Changes)
+		}
+		report.Results = append(report.Results, result)
+	}
+	report.Duration = time.Since(report.Started)
+
+	if !t.DryRun {
+		if err := report.save(t); err != nil {
+			logrus.Errorf("failed to save the %s apply report: %v", phase, err)
 		}
 	}
 
 	if len(errors) > 0 {
-		return cli.NewMultiError(errors...)
+		return report, cli.NewMultiError(errors...)
 	}
 
-	return nil
+	return report, nil
 }
 
-func RunApply(cfg *config.CloudConfig) error {
-	return runApplies(cfg,
-		ApplyModules,
-		ApplySSHKeysWithNet,
-		ApplyWriteFiles,
-		ApplyEnvironment,
-		ApplyRuncmd,
-		ApplyInstall,
-		ApplyK3SInstall,
-	)
+func RunApply(t *target.Target, cfg *config.CloudConfig) (*Report, error) {
+	return Apply(t, cfg, PhaseRuntime, Selection{})
 }
 
-func InstallApply(cfg *config.CloudConfig) error {
-	return runApplies(cfg,
-		ApplyK3SWithRestart,
-	)
+func InstallApply(t *target.Target, cfg *config.CloudConfig) (*Report, error) {
+	return Apply(t, cfg, PhaseInstall, Selection{})
 }
 
-func BootApply(cfg *config.CloudConfig) error {
-	return runApplies(cfg,
-		ApplyDataSource,
-		ApplyModules,
-		ApplySysctls,
-		ApplyHostname,
-		ApplyDNS,
-		ApplyWifi,
-		ApplyPassword,
-		ApplySSHKeys,
-		ApplyK3SNoRestart,
-		ApplyWriteFiles,
-		ApplyEnvironment,
-		ApplyBootcmd,
-	)
+func BootApply(t *target.Target, cfg *config.CloudConfig) (*Report, error) {
+	return Apply(t, cfg, PhaseBoot, Selection{})
 }
 
-func InitApply(cfg *config.CloudConfig) error {
-	return runApplies(cfg,
-		ApplyModules,
-		ApplySysctls,
-		ApplyHostname,
-		ApplyWriteFiles,
-		ApplyEnvironment,
-		ApplyInitcmd,
-	)
+func InitApply(t *target.Target, cfg *config.CloudConfig) (*Report, error) {
+	return Apply(t, cfg, PhaseInitrd, Selection{})
 }
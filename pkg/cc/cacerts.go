@@ -0,0 +1,113 @@
+package cc
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+const (
+	// CABundlePath is the bundle of trusted certificates read by Go programs, curl and OpenSSL
+	CABundlePath = "/etc/ssl/certs/ca-certificates.crt"
+	// CACertsPath holds only the certificates of caCerts, registries.yaml points containerd to it
+	CACertsPath = "/etc/ssl/certs/k3os-ca-certificates.crt"
+	// LocalCACertsDir is where the trust store keeps the certificates added to the system ones
+	LocalCACertsDir = "/usr/local/share/ca-certificates"
+
+	// caBundleMarker separates the certificates of caCerts from the system ones in the bundle
+	caBundleMarker = "# k3os caCerts\n"
+)
+
+// caCertsPEM returns the certificates of caCerts re-encoded as PEM, so only certificates end up in the trust store
+func caCertsPEM(cfg *config.CloudConfig) ([][]byte, error) {
+	var result [][]byte
+	for i, c := range cfg.CACerts {
+		certs, err := util.ParseCertificates([]byte(c))
+		if err != nil {
+			return nil, fmt.Errorf("caCerts[%d]: %v", i, err)
+		}
+		buf := &bytes.Buffer{}
+		for _, cert := range certs {
+			if err := pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, buf.Bytes())
+	}
+	return result, nil
+}
+
+// ApplyCACerts adds the certificates of caCerts to the trust store and rebuilds the bundle the way
+// update-ca-certificates would, the certificates added before are dropped first so removing one from caCerts removes
+// it from the bundle too.  The downloads of k3os trust them right away.
+func ApplyCACerts(t *target.Target, cfg *config.CloudConfig) error {
+	certs, err := caCertsPEM(cfg)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := t.ReadFile(CABundlePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	cut := bytes.Index(bundle, []byte(caBundleMarker))
+	old, err := filepath.Glob(filepath.Join(t.Path(LocalCACertsDir), "k3os-*.crt"))
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 && cut < 0 && len(old) == 0 {
+		return skip("no CA certificates configured")
+	}
+	if cut >= 0 {
+		bundle = bundle[:cut]
+	}
+
+	keep := map[string]bool{}
+	if len(certs) > 0 {
+		if err := t.MkdirAll(LocalCACertsDir, 0755); err != nil {
+			return err
+		}
+	}
+	for i, cert := range certs {
+		p := filepath.Join(LocalCACertsDir, fmt.Sprintf("k3os-%d.crt", i))
+		keep[p] = true
+		if err := t.WriteFile(p, cert, 0644); err != nil {
+			return err
+		}
+	}
+	for _, p := range old {
+		if p = filepath.Join(LocalCACertsDir, filepath.Base(p)); !keep[p] {
+			if err := t.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	all := bytes.Join(certs, nil)
+	if len(all) > 0 {
+		if len(bundle) > 0 && !bytes.HasSuffix(bundle, []byte("\n")) {
+			bundle = append(bundle, '\n')
+		}
+		bundle = append(append(bundle, caBundleMarker...), all...)
+		if err := t.MkdirAll(filepath.Dir(CACertsPath), 0755); err != nil {
+			return err
+		}
+		if err := t.WriteFileAtomic(CACertsPath, all, 0644); err != nil {
+			return err
+		}
+	} else if err := t.Remove(CACertsPath); err != nil {
+		return err
+	}
+	if err := t.WriteFileAtomic(CABundlePath, bundle, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", CABundlePath, err)
+	}
+
+	util.SetCACerts(all)
+	return nil
+}
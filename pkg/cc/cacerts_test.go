@@ -0,0 +1,123 @@
+package cc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+func testCACert(t *testing.T, name string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestApplyCACerts(t *testing.T) {
+	root, err := ioutil.TempDir("", "cacerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer util.SetCACerts(nil)
+
+	system := testCACert(t, "system")
+	if err := os.MkdirAll(filepath.Join(root, "etc/ssl/certs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, CABundlePath), []byte(system), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := testCACert(t, "first"), testCACert(t, "second")
+	cfg := &config.CloudConfig{CACerts: []string{first, second}}
+	for i := 0; i < 2; i++ {
+		// the second time nothing may be added twice
+		if err := ApplyCACerts(target.New(root, false), cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bundle, err := ioutil.ReadFile(filepath.Join(root, CABundlePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := system + caBundleMarker + first + second; string(bundle) != expected {
+		t.Errorf("expected bundle:\n%s\ngot:\n%s", expected, bundle)
+	}
+	if _, err := os.Stat(filepath.Join(root, LocalCACertsDir, "k3os-1.crt")); err != nil {
+		t.Error(err)
+	}
+
+	cfg.CACerts = cfg.CACerts[:1]
+	if err := ApplyCACerts(target.New(root, false), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, LocalCACertsDir, "k3os-1.crt")); !os.IsNotExist(err) {
+		t.Errorf("expected k3os-1.crt to be removed, got %v", err)
+	}
+	if ca, _ := ioutil.ReadFile(filepath.Join(root, CACertsPath)); string(ca) != first {
+		t.Errorf("unexpected %s:\n%s", CACertsPath, ca)
+	}
+
+	cfg.CACerts = []string{"not a certificate"}
+	if err := ApplyCACerts(target.New(root, false), cfg); err == nil {
+		t.Error("expected an error for an invalid certificate")
+	}
+}
+
+func TestRegistriesTrustCACerts(t *testing.T) {
+	cfg := &config.CloudConfig{
+		CACerts: []string{testCACert(t, "ca")},
+		K3OS: config.K3OS{Registries: &config.Registries{
+			Mirrors: map[string]config.Mirror{
+				"docker.io": {Endpoints: []string{"https://mirror.internal:5000"}},
+			},
+			Configs: map[string]config.RegistryConfig{
+				"registry.internal": {TLS: &config.RegistryTLS{CAFile: "/etc/own-ca.crt"}},
+			},
+		}},
+	}
+	content, err := registriesConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"mirror.internal:5000:\n    tls:\n      ca_file: " + CACertsPath,
+		"registry.internal:\n    tls:\n      ca_file: /etc/own-ca.crt",
+	} {
+		if !bytes.Contains(content, []byte(expected)) {
+			t.Errorf("expected %q in:\n%s", expected, content)
+		}
+	}
+	if strings.Count(string(content), "ca_file") != 2 {
+		t.Errorf("unexpected registries.yaml:\n%s", content)
+	}
+}
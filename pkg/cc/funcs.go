@@ -1,10 +1,8 @@
 package cc
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"sort"
@@ -16,81 +14,153 @@ import (
 	"github.com/rancher/k3os/pkg/hostname"
 	"github.com/rancher/k3os/pkg/mode"
 	"github.com/rancher/k3os/pkg/module"
+	"github.com/rancher/k3os/pkg/network"
 	"github.com/rancher/k3os/pkg/ssh"
+	"github.com/rancher/k3os/pkg/storage"
 	"github.com/rancher/k3os/pkg/sysctl"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/users"
+	"github.com/rancher/k3os/pkg/util"
 	"github.com/rancher/k3os/pkg/version"
 	"github.com/rancher/k3os/pkg/writefile"
 	"github.com/sirupsen/logrus"
 )
 
-func ApplyModules(cfg *config.CloudConfig) error {
-	return module.LoadModules(cfg)
+func ApplyModules(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.K3OS.Modules) == 0 {
+		return skip("no modules configured")
+	}
+	return module.LoadModules(t, cfg)
 }
 
-func ApplySysctls(cfg *config.CloudConfig) error {
-	return sysctl.ConfigureSysctl(cfg)
+func ApplySysctls(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.K3OS.Sysctls) == 0 {
+		return skip("no sysctls configured")
+	}
+	return sysctl.ConfigureSysctl(t, cfg)
 }
 
-func ApplyHostname(cfg *config.CloudConfig) error {
-	return hostname.SetHostname(cfg)
+func ApplyHostname(t *target.Target, cfg *config.CloudConfig) error {
+	if cfg.Hostname == "" {
+		return skip("no hostname configured")
+	}
+	return hostname.SetHostname(t, cfg)
 }
 
-func ApplyPassword(cfg *config.CloudConfig) error {
-	return command.SetPassword(cfg.K3OS.Password)
+func ApplyPassword(t *target.Target, cfg *config.CloudConfig) error {
+	if cfg.K3OS.Password == "" {
+		return skip("no password configured")
+	}
+	return command.SetPassword(t, config.DefaultUser, cfg.K3OS.Password)
 }
 
-func ApplyRuncmd(cfg *config.CloudConfig) error {
-	return command.ExecuteCommand(cfg.Runcmd)
+func ApplyRuncmd(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.Runcmd) == 0 {
+		return skip("no commands configured")
+	}
+	return command.ExecuteCommand(t, cfg.Runcmd)
 }
 
-func ApplyBootcmd(cfg *config.CloudConfig) error {
-	return command.ExecuteCommand(cfg.Bootcmd)
+func ApplyBootcmd(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.Bootcmd) == 0 {
+		return skip("no commands configured")
+	}
+	return command.ExecuteCommand(t, cfg.Bootcmd)
 }
 
-func ApplyInitcmd(cfg *config.CloudConfig) error {
-	return command.ExecuteCommand(cfg.Initcmd)
+func ApplyInitcmd(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.Initcmd) == 0 {
+		return skip("no commands configured")
+	}
+	return command.ExecuteCommand(t, cfg.Initcmd)
 }
 
-func ApplyWriteFiles(cfg *config.CloudConfig) error {
-	writefile.WriteFiles(cfg)
-	return nil
+func ApplyWriteFiles(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.WriteFiles) == 0 {
+		return skip("no files configured")
+	}
+	return writefile.WriteFiles(t, cfg)
 }
 
-func ApplySSHKeys(cfg *config.CloudConfig) error {
-	return ssh.SetAuthorizedKeys(cfg, false)
+func ApplyUsers(t *target.Target, cfg *config.CloudConfig) error {
+	return users.ConfigureUsers(t, cfg, false)
 }
 
-func ApplySSHKeysWithNet(cfg *config.CloudConfig) error {
-	return ssh.SetAuthorizedKeys(cfg, true)
+func ApplyUsersWithNet(t *target.Target, cfg *config.CloudConfig) error {
+	return users.ConfigureUsers(t, cfg, true)
 }
 
-func ApplyK3SWithRestart(cfg *config.CloudConfig) error {
-	return ApplyK3S(cfg, true, false)
+func ApplySSHKeys(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.SSHAuthorizedKeys) == 0 {
+		return skip("no SSH keys configured")
+	}
+	return ssh.SetAuthorizedKeys(t, cfg, false)
 }
 
-func ApplyK3SInstall(cfg *config.CloudConfig) error {
-	return ApplyK3S(cfg, true, true)
+func ApplySSHKeysWithNet(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.SSHAuthorizedKeys) == 0 {
+		return skip("no SSH keys configured")
+	}
+	return ssh.SetAuthorizedKeys(t, cfg, true)
 }
 
-func ApplyK3SNoRestart(cfg *config.CloudConfig) error {
-	return ApplyK3S(cfg, false, false)
+func ApplyK3SWithRestart(t *target.Target, cfg *config.CloudConfig) error {
+	return ApplyK3S(t, cfg, true, false)
 }
 
-func ApplyK3S(cfg *config.CloudConfig, restart, install bool) error {
-	mode, err := mode.Get()
+func ApplyK3SInstall(t *target.Target, cfg *config.CloudConfig) error {
+	return ApplyK3S(t, cfg, true, true)
+}
+
+func ApplyK3SNoRestart(t *target.Target, cfg *config.CloudConfig) error {
+	return ApplyK3S(t, cfg, false, false)
+}
+
+func ApplyK3S(t *target.Target, cfg *config.CloudConfig, restart, install bool) error {
+	args, vars, err := k3sInstallArgs(t, cfg, restart, install)
 	if err != nil {
 		return err
 	}
+
+	files, err := writeK3SConfig(t, cfg, args)
+	if err != nil {
+		return err
+	}
+
+	fingerprint := k3sFingerprint(args, vars, files)
+	if !t.Force && fingerprint == readK3SFingerprint(t) {
+		return skip("k3s configuration is unchanged")
+	}
+
+	cmd := exec.Command("/usr/libexec/k3os/k3s-install.sh", args...)
+	cmd.Env = append(os.Environ(), vars...)
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stdin = os.Stdin
+	logrus.Debugf("Running %s %v %v", cmd.Path, cmd.Args, vars)
+
+	if err := t.Run(cmd); err != nil {
+		return err
+	}
+	return saveK3SFingerprint(t, fingerprint)
+}
+
+// k3sInstallArgs returns the arguments and the environment variables of the k3s install script
+func k3sInstallArgs(t *target.Target, cfg *config.CloudConfig, restart, install bool) ([]string, []string, error) {
+	mode, err := mode.Get(t.Root)
+	if err != nil {
+		return nil, nil, err
+	}
 	if mode == "install" {
-		return nil
+		return nil, nil, skip("k3s is not run in install mode")
 	}
 
 	k3sExists := false
 	k3sLocalExists := false
-	if _, err := os.Stat("/sbin/k3s"); err == nil {
+	if _, err := t.Stat("/sbin/k3s"); err == nil {
 		k3sExists = true
 	}
-	if _, err := os.Stat("/usr/local/bin/k3s"); err == nil {
+	if _, err := t.Stat("/usr/local/bin/k3s"); err == nil {
 		k3sLocalExists = true
 	}
 
@@ -100,7 +170,7 @@ func ApplyK3S(cfg *config.CloudConfig, restart, install bool) error {
 	}
 
 	if !k3sExists && !restart {
-		return nil
+		return nil, nil, skip("k3s is not installed")
 	}
 
 	if k3sExists {
@@ -110,7 +180,7 @@ func ApplyK3S(cfg *config.CloudConfig, restart, install bool) error {
 	} else if k3sLocalExists {
 		vars = append(vars, "INSTALL_K3S_SKIP_DOWNLOAD=true")
 	} else if !install {
-		return nil
+		return nil, nil, skip("k3s is not installed")
 	}
 
 	if !restart {
@@ -134,6 +204,9 @@ func ApplyK3S(cfg *config.CloudConfig, restart, install bool) error {
 		vars = append(vars, fmt.Sprintf("K3S_CLUSTER_SECRET=\"%s\"\n", cfg.K3OS.Token))
 	}
 
+	// the install script copies the proxy variables to the environment file of the k3s service
+	vars = append(vars, proxyVars(cfg)...)
+
 	var labels []string
 	for k, v := range cfg.K3OS.Labels {
 		labels = append(labels, fmt.Sprintf("%s=%s", k, v))
@@ -151,40 +224,39 @@ func ApplyK3S(cfg *config.CloudConfig, restart, install bool) error {
 	for _, taint := range cfg.K3OS.Taints {
 		args = append(args, "--kubelet-arg", "register-with-taints="+taint)
 	}
+	args = append(args, swapKubeletArgs(cfg)...)
 
-	cmd := exec.Command("/usr/libexec/k3os/k3s-install.sh", args...)
-	cmd.Env = append(os.Environ(), vars...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
-	logrus.Debugf("Running %s %v %v", cmd.Path, cmd.Args, vars)
-
-	return cmd.Run()
+	return args, vars, nil
 }
 
-func ApplyInstall(cfg *config.CloudConfig) error {
-	mode, err := mode.Get()
+func ApplyInstall(t *target.Target, cfg *config.CloudConfig) error {
+	mode, err := mode.Get(t.Root)
 	if err != nil {
 		return err
 	}
 	if mode != "install" {
-		return nil
+		return skip("not in install mode")
 	}
 
 	cmd := exec.Command("k3os", "install")
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 	cmd.Stdin = os.Stdin
-	return cmd.Run()
+	return t.Run(cmd)
 }
 
-func ApplyDNS(cfg *config.CloudConfig) error {
+func ApplyDNS(t *target.Target, cfg *config.CloudConfig) error {
 	buf := &bytes.Buffer{}
 	buf.WriteString("[General]\n")
-	buf.WriteString("NetworkInterfaceBlacklist=veth\n")
+	// connman leaves the virtual interfaces, and the members of bonds and bridges, to k3os.network
+	blacklist := append([]string{"veth"}, network.Unmanaged(cfg.K3OS.Network)...)
+	buf.WriteString("NetworkInterfaceBlacklist=")
+	buf.WriteString(strings.Join(blacklist, ","))
+	buf.WriteString("\n")
 	buf.WriteString("PreferredTechnologies=ethernet,wifi\n")
-	if len(cfg.K3OS.DNSNameservers) > 0 {
-		dns := strings.Join(cfg.K3OS.DNSNameservers, ",")
+	nameservers := append(append([]string{}, cfg.K3OS.DNSNameservers...), network.Nameservers(cfg.K3OS.Network)...)
+	if len(nameservers) > 0 {
+		dns := strings.Join(nameservers, ",")
 		buf.WriteString("FallbackNameservers=")
 		buf.WriteString(dns)
 		buf.WriteString("\n")
@@ -198,8 +270,12 @@ func ApplyDNS(cfg *config.CloudConfig) error {
 		buf.WriteString(ntp)
 		buf.WriteString("\n")
 	}
+	if cfg.K3OS.Time != nil {
+		// k3os.time keeps the clock in sync instead
+		buf.WriteString("TimeUpdates=manual\n")
+	}
 
-	err := ioutil.WriteFile("/etc/connman/main.conf", buf.Bytes(), 0644)
+	err := t.WriteFile("/etc/connman/main.conf", buf.Bytes(), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write /etc/connman/main.conf: %v", err)
 	}
@@ -207,9 +283,38 @@ func ApplyDNS(cfg *config.CloudConfig) error {
 	return nil
 }
 
-func ApplyWifi(cfg *config.CloudConfig) error {
+func ApplyDisks(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.Disks) == 0 {
+		return skip("no disks configured")
+	}
+	if !t.IsLive() {
+		return skip("disks are only set up on the running system")
+	}
+	return storage.FormatDisks(t, cfg.Disks)
+}
+
+func ApplyMounts(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.Mounts) == 0 {
+		return skip("no mounts configured")
+	}
+	if !t.IsLive() {
+		return skip("filesystems are only mounted on the running system")
+	}
+	return storage.Mount(t, cfg.Mounts)
+}
+
+func ApplyNetwork(t *target.Target, cfg *config.CloudConfig) error {
+	if cfg.K3OS.Network == nil {
+		if _, err := t.Stat(network.ConnmanConfigPath); os.IsNotExist(err) {
+			return skip("no network configured")
+		}
+	}
+	return network.Configure(t, cfg.K3OS.Network)
+}
+
+func ApplyWifi(t *target.Target, cfg *config.CloudConfig) error {
 	if len(cfg.K3OS.Wifi) == 0 {
-		return nil
+		return skip("no wifi networks configured")
 	}
 
 	buf := &bytes.Buffer{}
@@ -219,10 +324,10 @@ func ApplyWifi(cfg *config.CloudConfig) error {
 	buf.WriteString("Tethering=false\n")
 
 	if buf.Len() > 0 {
-		if err := os.MkdirAll("/var/lib/connman", 0755); err != nil {
+		if err := t.MkdirAll("/var/lib/connman", 0755); err != nil {
 			return fmt.Errorf("failed to mkdir /var/lib/connman: %v", err)
 		}
-		if err := ioutil.WriteFile("/var/lib/connman/settings", buf.Bytes(), 0644); err != nil {
+		if err := t.WriteFile("/var/lib/connman/settings", buf.Bytes(), 0644); err != nil {
 			return fmt.Errorf("failed to write to /var/lib/connman/settings: %v", err)
 		}
 	}
@@ -248,71 +353,87 @@ func ApplyWifi(cfg *config.CloudConfig) error {
 	}
 
 	if buf.Len() > 0 {
-		return ioutil.WriteFile("/var/lib/connman/cloud-config.config", buf.Bytes(), 0644)
+		t.Secret("/var/lib/connman/cloud-config.config")
+		return t.WriteFile("/var/lib/connman/cloud-config.config", buf.Bytes(), 0644)
 	}
 
 	return nil
 }
 
-func ApplyDataSource(cfg *config.CloudConfig) error {
+func ApplyDataSource(t *target.Target, cfg *config.CloudConfig) error {
 	if len(cfg.K3OS.DataSources) == 0 {
-		return nil
+		return skip("no data sources configured")
 	}
 
 	args := strings.Join(cfg.K3OS.DataSources, " ")
 	buf := &bytes.Buffer{}
 
-	buf.WriteString("command_args=\"")
+	buf.WriteString("command_args=\"datasource ")
 	buf.WriteString(args)
 	buf.WriteString("\"\n")
 
-	if err := ioutil.WriteFile("/etc/conf.d/cloud-config", buf.Bytes(), 0644); err != nil {
+	if err := t.WriteFile("/etc/conf.d/cloud-config", buf.Bytes(), 0644); err != nil {
 		return fmt.Errorf("failed to write to /etc/conf.d/cloud-config: %v", err)
 	}
 
 	return nil
 }
 
-func ApplyEnvironment(cfg *config.CloudConfig) error {
+func ApplyEnvironment(t *target.Target, cfg *config.CloudConfig) error {
 	if len(cfg.K3OS.Environment) == 0 {
-		return nil
-	}
-	env := make(map[string]string, len(cfg.K3OS.Environment))
-	if buf, err := ioutil.ReadFile("/etc/environment"); err == nil {
-		scanner := bufio.NewScanner(bytes.NewReader(buf))
-		for scanner.Scan() {
-			line := scanner.Text()
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "#") {
-				continue
-			}
-			line = strings.TrimPrefix(line, "export")
-			line = strings.TrimSpace(line)
-			if len(line) > 1 {
-				parts := strings.SplitN(line, "=", 2)
-				key := parts[0]
-				val := ""
-				if len(parts) > 1 {
-					if val, err = strconv.Unquote(parts[1]); err != nil {
-						val = parts[1]
-					}
-				}
-				env[key] = val
-			}
-		}
+		return skip("no environment configured")
 	}
-	for key, val := range cfg.K3OS.Environment {
+	return updateEnvironment(t, cfg.K3OS.Environment)
+}
+
+// updateEnvironment sets vars in /etc/environment, the other variables there are kept
+func updateEnvironment(t *target.Target, vars map[string]string) error {
+	env := map[string]string{}
+	if buf, err := t.ReadFile(util.EnvironmentFile); err == nil {
+		env = util.ParseEnvironment(buf)
+	}
+	for key, val := range vars {
 		env[key] = val
 	}
+	return writeEnvironment(t, env)
+}
+
+// removeEnvironment removes keys from /etc/environment, it returns false if none of them were set
+func removeEnvironment(t *target.Target, keys []string) (bool, error) {
+	buf, err := t.ReadFile(util.EnvironmentFile)
+	if err != nil {
+		return false, nil
+	}
+	env := util.ParseEnvironment(buf)
+	removed := false
+	for _, key := range keys {
+		if _, ok := env[key]; ok {
+			delete(env, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return false, nil
+	}
+	return true, writeEnvironment(t, env)
+}
+
+func writeEnvironment(t *target.Target, env map[string]string) error {
+	var keys []string
+	for key := range env {
+		keys = append(keys, key)
+	}
+	// sorted so the file only changes when the environment does
+	sort.Strings(keys)
 	buf := &bytes.Buffer{}
-	for key, val := range env {
+	for _, key := range keys {
 		buf.WriteString(key)
 		buf.WriteString("=")
-		buf.WriteString(strconv.Quote(val))
+		buf.WriteString(strconv.Quote(env[key]))
 		buf.WriteString("\n")
 	}
-	if err := ioutil.WriteFile("/etc/environment", buf.Bytes(), 0644); err != nil {
-		return fmt.Errorf("failed to write to /etc/environment: %v", err)
+	if err := t.WriteFile(util.EnvironmentFile, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write to %s: %v", util.EnvironmentFile, err)
 	}
 
 	return nil
@@ -0,0 +1,207 @@
+package cc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+const (
+	containerdSocket   = "/run/k3s/containerd/containerd.sock"
+	imageCheckTimeout  = 30 * time.Second
+	imageCheckInterval = 2 * time.Second
+)
+
+// imageTarballExtensions are the files k3s imports from the images directory
+var imageTarballExtensions = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz", ".tar.lz4"}
+
+// imagesDir returns the directory k3s imports image tarballs from when it starts
+func imagesDir(cfg *config.CloudConfig) string {
+	return filepath.Join(k3sDataDir(cfg), "agent", "images")
+}
+
+// imageTarballFiles returns the tarballs of a source, the file it names or the tarballs in the directory it names
+func imageTarballFiles(t *target.Target, source string) ([]string, error) {
+	p := source
+	if strings.Contains(source, "://") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "file" {
+			return nil, fmt.Errorf("unsupported URL %q, only file:// URLs can be used", source)
+		}
+		p = u.Path
+	}
+	if !filepath.IsAbs(p) {
+		return nil, fmt.Errorf("%q is not an absolute path", source)
+	}
+
+	info, err := t.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{p}, nil
+	}
+
+	var result []string
+	matches, err := filepath.Glob(filepath.Join(t.Path(p), "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		for _, ext := range imageTarballExtensions {
+			if strings.HasSuffix(m, ext) {
+				result = append(result, filepath.Join(p, filepath.Base(m)))
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// ApplyImages places the image tarballs in the directory k3s imports them from, after checking their sha256
+func ApplyImages(t *target.Target, cfg *config.CloudConfig) error {
+	if cfg.K3OS.Images == nil || len(cfg.K3OS.Images.Tarballs) == 0 {
+		return skip("no image tarballs configured")
+	}
+
+	dir := imagesDir(cfg)
+	if err := t.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, tarball := range cfg.K3OS.Images.Tarballs {
+		if err := placeImageTarball(t, dir, tarball); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", tarball.Source, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func placeImageTarball(t *target.Target, dir string, tarball config.ImageTarball) error {
+	files, err := imageTarballFiles(t, tarball.Source)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no image tarballs found")
+	}
+	if tarball.SHA256 != "" {
+		if len(files) != 1 || files[0] != strings.TrimPrefix(tarball.Source, "file://") {
+			return fmt.Errorf("sha256 can only be checked for a file")
+		}
+		sum, err := util.SHA256File(t.Path(files[0]))
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, tarball.SHA256) {
+			return fmt.Errorf("sha256 is %s, expected %s", sum, tarball.SHA256)
+		}
+	}
+
+	for _, f := range files {
+		if err := t.CopyFile(f, filepath.Join(dir, filepath.Base(f)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyImageCheck reports the image references that are not in the containerd store of the running k3s.  k3s imports
+// the tarballs when it starts, so they are given some time to show up.
+func ApplyImageCheck(t *target.Target, cfg *config.CloudConfig) error {
+	if cfg.K3OS.Images == nil || len(cfg.K3OS.Images.References) == 0 {
+		return skip("no image references configured")
+	}
+	if !t.IsLive() {
+		return skip("images are only checked on the running system")
+	}
+	if _, err := os.Stat(containerdSocket); err != nil {
+		return skip("k3s is not running")
+	}
+
+	deadline := time.Now().Add(imageCheckTimeout)
+	for {
+		present, err := listImages()
+		if err != nil {
+			return err
+		}
+		missing := missingImages(cfg.K3OS.Images.References, present)
+		if len(missing) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("missing images %s", strings.Join(missing, ", "))
+		}
+		time.Sleep(imageCheckInterval)
+	}
+}
+
+// listImages returns the images in the containerd store of k3s
+func listImages() ([]string, error) {
+	out, err := exec.Command("k3s", "ctr", "--namespace", "k8s.io", "images", "list", "--quiet").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+	var result []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			result = append(result, line)
+		}
+	}
+	return result, scanner.Err()
+}
+
+// missingImages returns the references that are not present
+func missingImages(references, present []string) []string {
+	have := map[string]bool{}
+	for _, p := range present {
+		have[normalizeImage(p)] = true
+	}
+	var missing []string
+	for _, ref := range references {
+		if !have[normalizeImage(ref)] {
+			missing = append(missing, ref)
+		}
+	}
+	return missing
+}
+
+// normalizeImage returns the fully qualified form of an image reference, the way containerd names it
+func normalizeImage(ref string) string {
+	name, digest := ref, ""
+	if i := strings.Index(ref, "@"); i >= 0 {
+		name, digest = ref[:i], ref[i:]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 1 {
+		name = "docker.io/library/" + name
+	} else if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		name = "docker.io/" + name
+	}
+
+	if digest == "" && !strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		name += ":latest"
+	}
+	return name + digest
+}
@@ -0,0 +1,81 @@
+package cc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestNormalizeImage(t *testing.T) {
+	for ref, expected := range map[string]string{
+		"nginx":                         "docker.io/library/nginx:latest",
+		"rancher/pause:3.1":             "docker.io/rancher/pause:3.1",
+		"docker.io/rancher/pause:3.1":   "docker.io/rancher/pause:3.1",
+		"registry.local:5000/app":       "registry.local:5000/app:latest",
+		"localhost/app:1":               "localhost/app:1",
+		"alpine@sha256:abc":             "docker.io/library/alpine@sha256:abc",
+		"quay.io/coreos/etcd:v3.4.13-0": "quay.io/coreos/etcd:v3.4.13-0",
+	} {
+		if got := normalizeImage(ref); got != expected {
+			t.Errorf("%s: expected %s, got %s", ref, expected, got)
+		}
+	}
+
+	missing := missingImages([]string{"rancher/pause:3.1", "nginx:1.19"}, []string{"docker.io/rancher/pause:3.1"})
+	if !reflect.DeepEqual(missing, []string{"nginx:1.19"}) {
+		t.Errorf("unexpected missing images %v", missing)
+	}
+}
+
+func TestApplyImages(t *testing.T) {
+	root, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	airgap := filepath.Join(root, "k3os/data/images")
+	if err := os.MkdirAll(airgap, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.tar": "a", "b.tar.gz": "b", "README": "c"} {
+		if err := ioutil.WriteFile(filepath.Join(airgap, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tgt := target.New(root, false)
+	cfg := &config.CloudConfig{}
+	cfg.K3OS.Images = &config.Images{
+		Tarballs: []config.ImageTarball{
+			{Source: "/k3os/data/images"},
+			// sha256 of "a"
+			{Source: "file:///k3os/data/images/a.tar", SHA256: "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"},
+		},
+	}
+	if err := ApplyImages(tgt, cfg); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, defaultK3SDataDir, "agent/images")
+	for name, exists := range map[string]bool{"a.tar": true, "b.tar.gz": true, "README": false} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Errorf("expected %s to exist: %v", name, exists)
+		}
+	}
+	if changes := len(tgt.Changes()); changes != 3 {
+		t.Errorf("expected the directory to be created and 2 tarballs copied, got %v", tgt.Changes())
+	}
+
+	cfg.K3OS.Images.Tarballs[1].SHA256 = "0000"
+	if err := ApplyImages(tgt, cfg); err == nil {
+		t.Error("expected a sha256 mismatch")
+	}
+	if changes := len(tgt.Changes()); changes != 3 {
+		t.Errorf("expected the tarballs to be left alone, got %v", tgt.Changes())
+	}
+}
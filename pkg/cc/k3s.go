@@ -0,0 +1,175 @@
+package cc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/system"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultK3SDataDir = "/var/lib/rancher/k3s"
+
+// K3SConfigPath is where the k3os.k3s section is written, k3s reads it every time it starts
+const K3SConfigPath = "/etc/rancher/k3s/config.yaml"
+
+// K3SFingerprintPath is where the fingerprint of the last k3s installation is kept, it does not survive a reboot so
+// k3s is always set up again at boot
+var K3SFingerprintPath = system.StatePath("k3s.fingerprint")
+
+// k3sFingerprint returns a stable hash of the k3s installation with args, the install script variables vars and the
+// content of the files k3s reads at startup, the variables only deciding whether k3s starts are left out
+func k3sFingerprint(args, vars []string, files map[string][]byte) string {
+	var env []string
+	for _, v := range vars {
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "INSTALL_K3S_SKIP_START=") {
+			continue
+		}
+		env = append(env, v)
+	}
+	sort.Strings(env)
+
+	bytes, _ := json.Marshal(struct {
+		Args  []string          `json:"args"`
+		Vars  []string          `json:"vars"`
+		Files map[string][]byte `json:"files,omitempty"`
+	}{args, env, files})
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// k3sDataDir returns the directory k3s keeps its state in
+func k3sDataDir(cfg *config.CloudConfig) string {
+	if cfg.K3OS.K3S != nil && cfg.K3OS.K3S.DataDir != "" {
+		return cfg.K3OS.K3S.DataDir
+	}
+	return defaultK3SDataDir
+}
+
+// k3sServer returns true if the k3s install script args run a server, the script decides the same way
+func k3sServer(cfg *config.CloudConfig, args []string) bool {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0] == "server"
+	}
+	return cfg.K3OS.ServerURL == ""
+}
+
+// k3sServerKeys are the config.yaml keys an agent does not accept
+var k3sServerKeys = map[string]bool{
+	"cluster-cidr":       true,
+	"service-cidr":       true,
+	"cluster-dns":        true,
+	"flannel-backend":    true,
+	"disable":            true,
+	"tls-san":            true,
+	"kube-apiserver-arg": true,
+}
+
+// k3sConfig returns the k3s config.yaml of the k3os.k3s section, or nil if there is none
+func k3sConfig(cfg *config.CloudConfig, server bool) ([]byte, error) {
+	k3s := cfg.K3OS.K3S
+	if k3s == nil {
+		return nil, nil
+	}
+
+	data := map[string]interface{}{}
+	set := func(key string, val interface{}) {
+		switch v := val.(type) {
+		case string:
+			if v == "" {
+				return
+			}
+		case []string:
+			if len(v) == 0 {
+				return
+			}
+		}
+		if !server && k3sServerKeys[key] {
+			logrus.Warnf("k3os.k3s: ignoring %s, it only applies to servers", key)
+			return
+		}
+		data[key] = val
+	}
+	set("node-name", k3s.NodeName)
+	set("node-ip", k3s.NodeIP)
+	set("node-external-ip", k3s.NodeExternalIP)
+	set("cluster-cidr", k3s.ClusterCIDR)
+	set("service-cidr", k3s.ServiceCIDR)
+	set("cluster-dns", k3s.ClusterDNS)
+	set("flannel-backend", k3s.FlannelBackend)
+	set("disable", k3s.Disable)
+	set("tls-san", k3s.TLSSAN)
+	set("kubelet-arg", k3s.KubeletArgs)
+	set("kube-apiserver-arg", k3s.APIServerArgs)
+	set("data-dir", k3s.DataDir)
+
+	return yaml.Marshal(data)
+}
+
+// writeK3SConfig writes config.yaml if the k3os.k3s section is set, otherwise a file placed there another way, such
+// as with write_files, is left alone.  It returns the files k3s reads at startup for the fingerprint, including the
+// registries.yaml written by ApplyRegistries and the certificates written by ApplyCACerts.
+func writeK3SConfig(t *target.Target, cfg *config.CloudConfig, args []string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+
+	registries, err := registriesConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if registries != nil {
+		files[RegistriesPath] = registries
+	}
+	certs, err := caCertsPEM(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) > 0 {
+		files[CACertsPath] = bytes.Join(certs, nil)
+	}
+
+	content, err := k3sConfig(cfg, k3sServer(cfg, args))
+	if err != nil || content == nil {
+		return files, err
+	}
+	if err := t.MkdirAll(filepath.Dir(K3SConfigPath), 0755); err != nil {
+		return nil, err
+	}
+	t.Secret(K3SConfigPath)
+	if err := t.WriteFileAtomic(K3SConfigPath, content, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", K3SConfigPath, err)
+	}
+	files[K3SConfigPath] = content
+	return files, nil
+}
+
+func readK3SFingerprint(t *target.Target) string {
+	bytes, err := t.ReadFile(K3SFingerprintPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes))
+}
+
+// saveK3SFingerprint is not recorded as a change of the target, it only describes the changes of the install script
+func saveK3SFingerprint(t *target.Target, fingerprint string) error {
+	if t.DryRun {
+		return nil
+	}
+	p := t.Path(K3SFingerprintPath)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(p, []byte(fingerprint+"\n"), 0600)
+}
@@ -0,0 +1,125 @@
+package cc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestK3SFingerprint(t *testing.T) {
+	args := []string{"server", "--node-label", "a=b"}
+	vars := []string{"INSTALL_K3S_NAME=service", "K3S_TOKEN=\"K10abc\"\n"}
+
+	fingerprint := k3sFingerprint(args, vars, nil)
+	if got := k3sFingerprint(args, []string{"K3S_TOKEN=\"K10abc\"\n", "INSTALL_K3S_SKIP_START=true",
+		"INSTALL_K3S_NAME=service"}, nil); got != fingerprint {
+		t.Error("expected the order of the variables and whether k3s starts to be ignored")
+	}
+	if k3sFingerprint([]string{"server", "--node-label", "a=c"}, vars, nil) == fingerprint {
+		t.Error("expected a different fingerprint for different args")
+	}
+	if k3sFingerprint(args, []string{"INSTALL_K3S_NAME=service", "K3S_TOKEN=\"K10def\"\n"}, nil) == fingerprint {
+		t.Error("expected a different fingerprint for a different token")
+	}
+	if k3sFingerprint(args, vars, map[string][]byte{K3SConfigPath: []byte("node-ip: 10.0.0.1\n")}) == fingerprint {
+		t.Error("expected a different fingerprint for a different config.yaml")
+	}
+}
+
+func TestApplyK3SUnchanged(t *testing.T) {
+	root, err := ioutil.TempDir("", "k3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "sbin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "sbin/k3s"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tgt := target.New(root, true)
+	tgt.Out = ioutil.Discard
+	cfg := &config.CloudConfig{}
+	if err := ApplyK3SWithRestart(tgt, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(tgt.Changes()) != 1 {
+		t.Fatalf("expected the install script to run, got %v", tgt.Changes())
+	}
+
+	// what the install script would have saved outside of dry-run mode
+	args, vars, err := k3sInstallArgs(tgt, cfg, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt.DryRun = false
+	if err := saveK3SFingerprint(tgt, k3sFingerprint(args, vars, nil)); err != nil {
+		t.Fatal(err)
+	}
+	tgt.DryRun = true
+
+	if err := ApplyK3SWithRestart(tgt, cfg); err == nil {
+		t.Fatal("expected k3s to be skipped when the configuration is unchanged")
+	} else if _, ok := err.(skipError); !ok {
+		t.Fatal(err)
+	}
+
+	tgt.Force = true
+	if err := ApplyK3SWithRestart(tgt, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(tgt.Changes()) != 2 {
+		t.Fatalf("expected the install script to run again with force, got %v", tgt.Changes())
+	}
+}
+
+func TestK3SConfig(t *testing.T) {
+	cfg := &config.CloudConfig{}
+	if content, err := k3sConfig(cfg, true); err != nil || content != nil {
+		t.Fatalf("expected no config.yaml without k3os.k3s, got %q %v", content, err)
+	}
+
+	cfg.K3OS.K3S = &config.K3S{
+		NodeIP:      "10.0.0.1",
+		ClusterCIDR: "10.42.0.0/16",
+		Disable:     []string{"traefik"},
+		KubeletArgs: []string{"max-pods=200"},
+	}
+	server := `cluster-cidr: 10.42.0.0/16
+disable:
+- traefik
+kubelet-arg:
+- max-pods=200
+node-ip: 10.0.0.1
+`
+	agent := `kubelet-arg:
+- max-pods=200
+node-ip: 10.0.0.1
+`
+	for _, test := range []struct {
+		server   bool
+		expected string
+	}{{true, server}, {false, agent}} {
+		content, err := k3sConfig(cfg, test.server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != test.expected {
+			t.Errorf("server %v: got:\n%s\nexpected:\n%s", test.server, content, test.expected)
+		}
+	}
+
+	if !k3sServer(cfg, nil) || k3sServer(cfg, []string{"agent"}) {
+		t.Error("expected a server without a server URL unless the args say otherwise")
+	}
+	cfg.K3OS.ServerURL = "https://10.0.0.2:6443"
+	if k3sServer(cfg, []string{"--node-label", "a=b"}) || !k3sServer(cfg, []string{"server"}) {
+		t.Error("expected an agent with a server URL unless the args say otherwise")
+	}
+}
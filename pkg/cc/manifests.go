@@ -0,0 +1,186 @@
+package cc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/system"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+// ManifestsListPath is where the paths of the manifests written from the configuration are kept, so the ones dropped
+// from it are removed and everything else in the manifests directory is left alone
+var ManifestsListPath = system.LocalPath("manifests.json")
+
+// helmChart is the HelmChart resource of the k3s helm controller
+type helmChart struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   helmChartMetadata `json:"metadata"`
+	Spec       helmChartSpec     `json:"spec"`
+}
+
+type helmChartMetadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type helmChartSpec struct {
+	Repo            string            `json:"repo,omitempty"`
+	Chart           string            `json:"chart"`
+	Version         string            `json:"version,omitempty"`
+	TargetNamespace string            `json:"targetNamespace,omitempty"`
+	Set             map[string]string `json:"set,omitempty"`
+	ValuesContent   string            `json:"valuesContent,omitempty"`
+}
+
+// manifestsDir returns the directory the k3s server deploys the manifests of
+func manifestsDir(cfg *config.CloudConfig) string {
+	return filepath.Join(k3sDataDir(cfg), "server", "manifests")
+}
+
+// manifests returns the content of the manifests and helm charts of the configuration keyed by file name
+func manifests(t *target.Target, cfg *config.CloudConfig) (map[string][]byte, error) {
+	result := map[string][]byte{}
+	add := func(name string, content []byte) error {
+		if name == "" || name != filepath.Base(name) || name[0] == '.' {
+			return fmt.Errorf("invalid manifest name %q", name)
+		}
+		file := name + ".yaml"
+		if _, ok := result[file]; ok {
+			return fmt.Errorf("more than one manifest or helm chart is named %q", name)
+		}
+		result[file] = content
+		return nil
+	}
+
+	for _, m := range cfg.K3OS.Manifests {
+		var (
+			content []byte
+			err     error
+		)
+		if m.File != "" {
+			content, err = t.ReadFile(m.File)
+		} else {
+			content, err = util.DecodeContent(m.Content, m.Encoding)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %v", m.Name, err)
+		}
+		if err := add(m.Name, content); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range cfg.K3OS.HelmCharts {
+		if c.Chart == "" {
+			return nil, fmt.Errorf("helm chart %s: chart is required", c.Name)
+		}
+		namespace := c.Namespace
+		if namespace == "" {
+			namespace = "kube-system"
+		}
+		content, err := yaml.Marshal(helmChart{
+			APIVersion: "helm.cattle.io/v1",
+			Kind:       "HelmChart",
+			Metadata: helmChartMetadata{
+				Name:      c.Name,
+				Namespace: namespace,
+			},
+			Spec: helmChartSpec{
+				Repo:            c.Repo,
+				Chart:           c.Chart,
+				Version:         c.Version,
+				TargetNamespace: c.TargetNamespace,
+				Set:             c.Set,
+				ValuesContent:   c.Values,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := add(c.Name, content); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// ApplyManifests writes the manifests and helm charts to the manifests directory of a k3s server, and removes the
+// ones written before that are no longer configured
+func ApplyManifests(t *target.Target, cfg *config.CloudConfig) error {
+	written := readManifestsList(t)
+	if len(cfg.K3OS.Manifests) == 0 && len(cfg.K3OS.HelmCharts) == 0 && len(written) == 0 {
+		return skip("no manifests configured")
+	}
+	if !k3sServer(cfg, cfg.K3OS.K3sArgs) {
+		return skip("manifests are only deployed by servers")
+	}
+
+	files, err := manifests(t, cfg)
+	if err != nil {
+		return err
+	}
+
+	dir := manifestsDir(cfg)
+	if len(files) > 0 {
+		if err := t.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	var paths []string
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		t.Secret(p)
+		if err := t.WriteFileAtomic(p, content, 0600); err != nil {
+			return fmt.Errorf("failed to write manifest %s: %v", name, err)
+		}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range written {
+		if i := sort.SearchStrings(paths, p); i < len(paths) && paths[i] == p {
+			continue
+		}
+		if err := t.Remove(p); err != nil {
+			return fmt.Errorf("failed to remove manifest %s: %v", p, err)
+		}
+	}
+
+	return saveManifestsList(t, paths)
+}
+
+func readManifestsList(t *target.Target) []string {
+	var paths []string
+	bytes, err := t.ReadFile(ManifestsListPath)
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(bytes, &paths); err != nil {
+		return nil
+	}
+	return paths
+}
+
+// saveManifestsList is not recorded as a change of the target, the manifests are
+func saveManifestsList(t *target.Target, paths []string) error {
+	if t.DryRun {
+		return nil
+	}
+	bytes, err := json.Marshal(paths)
+	if err != nil {
+		return err
+	}
+	p := t.Path(ManifestsListPath)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(p, bytes, 0600)
+}
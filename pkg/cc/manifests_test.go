@@ -0,0 +1,62 @@
+package cc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestApplyManifests(t *testing.T) {
+	root, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	tgt := target.New(root, false)
+	dir := filepath.Join(root, defaultK3SDataDir, "server", "manifests")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "mine.yaml"), []byte("kind: ConfigMap\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.CloudConfig{}
+	cfg.K3OS.Manifests = []config.Manifest{
+		{Name: "app", Content: "kind: Deployment\n"},
+	}
+	cfg.K3OS.HelmCharts = []config.HelmChart{
+		{Name: "grafana", Repo: "https://grafana.github.io/helm-charts", Chart: "grafana", Values: "replicas: 2\n"},
+	}
+	if err := ApplyManifests(tgt, cfg); err != nil {
+		t.Fatal(err)
+	}
+	chart, err := ioutil.ReadFile(filepath.Join(dir, "grafana.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(chart), "kind: HelmChart") || !strings.Contains(string(chart), "valuesContent: |\n    replicas: 2") {
+		t.Errorf("unexpected helm chart:\n%s", chart)
+	}
+
+	cfg.K3OS.HelmCharts = nil
+	if err := ApplyManifests(tgt, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{"app.yaml": true, "grafana.yaml": false, "mine.yaml": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Errorf("expected %s to exist: %v", name, exists)
+		}
+	}
+
+	cfg.K3OS.ServerURL = "https://10.0.0.1:6443"
+	if _, ok := ApplyManifests(tgt, cfg).(skipError); !ok {
+		t.Error("expected agents to be skipped")
+	}
+}
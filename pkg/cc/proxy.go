@@ -0,0 +1,164 @@
+package cc
+
+import (
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+// the pod and service networks of k3s unless they are configured
+const (
+	defaultClusterCIDR = "10.42.0.0/16"
+	defaultServiceCIDR = "10.43.0.0/16"
+)
+
+// localNoProxy are never reached through the proxy: the loopback addresses, the link-local addresses of the cloud
+// metadata services, the metadata address on its own for tools that do not understand CIDRs, and the cluster domains
+var localNoProxy = []string{"localhost", "127.0.0.1", "::1", "169.254.169.254", "169.254.0.0/16", ".svc",
+	".cluster.local"}
+
+// nodeAddresses returns the hostname and the addresses of the running system, the addresses of k3os.network are
+// added to them as they might not be up yet
+var nodeAddresses = func() []string {
+	var hosts []string
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+			hosts = append(hosts, ipnet.IP.String())
+		}
+	}
+	return hosts
+}
+
+// ApplyProxy writes the proxy to /etc/environment, in upper and lower case as tools disagree on which to read, and
+// makes the downloads of k3os go through it.  k3s gets it from the install script, see k3sInstallArgs.  Without a
+// proxy the variables a previous proxy left in /etc/environment are removed, unless k3os.environment sets them.
+func ApplyProxy(t *target.Target, cfg *config.CloudConfig) error {
+	env := proxyEnv(cfg)
+	if env == nil {
+		util.ResetProxy()
+		var keys []string
+		for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+			for _, key := range []string{key, strings.ToLower(key)} {
+				if _, ok := cfg.K3OS.Environment[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+		}
+		removed, err := removeEnvironment(t, keys)
+		if err != nil || removed {
+			return err
+		}
+		return skip("no proxy configured")
+	}
+
+	p := cfg.K3OS.Proxy
+	util.SetProxy(util.Proxy{
+		HTTP:    p.HTTPProxy,
+		HTTPS:   p.HTTPSProxy,
+		NoProxy: strings.Split(env["NO_PROXY"], ","),
+	})
+
+	vars := map[string]string{}
+	for key, val := range env {
+		vars[key] = val
+		vars[strings.ToLower(key)] = val
+	}
+	return updateEnvironment(t, vars)
+}
+
+// proxyEnv returns the proxy environment variables of k3os.proxy, or nil if there is no proxy.  NO_PROXY always
+// holds the local and metadata addresses, the hostname and addresses of the node, the cluster domains and the cluster
+// and service CIDRs, so traffic within the node and the cluster never goes through the proxy.
+func proxyEnv(cfg *config.CloudConfig) map[string]string {
+	p := cfg.K3OS.Proxy
+	if p == nil || (p.HTTPProxy == "" && p.HTTPSProxy == "") {
+		return nil
+	}
+
+	env := map[string]string{}
+	if p.HTTPProxy != "" {
+		env["HTTP_PROXY"] = p.HTTPProxy
+	}
+	if p.HTTPSProxy != "" {
+		env["HTTPS_PROXY"] = p.HTTPSProxy
+	}
+
+	var (
+		noProxy []string
+		seen    = map[string]bool{}
+	)
+	hosts := append([]string{}, localNoProxy...)
+	hosts = append(hosts, p.NoProxy...)
+	hosts = append(hosts, cfg.Hostname)
+	hosts = append(hosts, nodeAddresses()...)
+	hosts = append(hosts, networkAddresses(cfg.K3OS.Network)...)
+	hosts = append(hosts, k3sCIDR(cfg, "cluster-cidr", defaultClusterCIDR), k3sCIDR(cfg, "service-cidr",
+		defaultServiceCIDR))
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if host != "" && !seen[host] {
+			seen[host] = true
+			noProxy = append(noProxy, host)
+		}
+	}
+	env["NO_PROXY"] = strings.Join(noProxy, ",")
+	return env
+}
+
+// networkAddresses returns the static addresses of k3os.network without their prefix length
+func networkAddresses(n *config.Network) []string {
+	if n == nil {
+		return nil
+	}
+	var addrs []string
+	for _, iface := range n.Interfaces {
+		for _, addr := range iface.Addresses {
+			if ip, _, err := net.ParseCIDR(addr); err == nil {
+				addrs = append(addrs, ip.String())
+			}
+		}
+	}
+	return addrs
+}
+
+// proxyVars returns the proxy environment variables in the KEY=value form of the k3s install script
+func proxyVars(cfg *config.CloudConfig) []string {
+	var vars []string
+	for key, val := range proxyEnv(cfg) {
+		vars = append(vars, key+"="+val)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// k3sCIDR returns the value of the k3s flag, the k3s args win over the k3os.k3s section as k3s gives its flags
+// precedence over config.yaml
+func k3sCIDR(cfg *config.CloudConfig, flag, def string) string {
+	args := cfg.K3OS.K3sArgs
+	for i, arg := range args {
+		if arg == "--"+flag && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--"+flag+"=") {
+			return strings.TrimPrefix(arg, "--"+flag+"=")
+		}
+	}
+	if k3s := cfg.K3OS.K3S; k3s != nil {
+		switch {
+		case flag == "cluster-cidr" && k3s.ClusterCIDR != "":
+			return k3s.ClusterCIDR
+		case flag == "service-cidr" && k3s.ServiceCIDR != "":
+			return k3s.ServiceCIDR
+		}
+	}
+	return def
+}
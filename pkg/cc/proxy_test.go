@@ -0,0 +1,92 @@
+package cc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+func TestApplyProxy(t *testing.T) {
+	root, err := ioutil.TempDir("", "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer util.SetProxy(util.Proxy{})
+	defer func(f func() []string) { nodeAddresses = f }(nodeAddresses)
+	nodeAddresses = func() []string { return []string{"node-1", "192.168.1.10", "fd00::10"} }
+
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/environment"), []byte("FOO=\"bar\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.CloudConfig{Hostname: "node-1", K3OS: config.K3OS{
+		Network: &config.Network{Interfaces: []config.NetworkInterface{
+			{Name: "eth1", Addresses: []string{"10.0.0.5/24", "192.168.1.10/24"}},
+		}},
+		K3sArgs: []string{"server", "--cluster-cidr", "10.100.0.0/16"},
+		K3S:     &config.K3S{ClusterCIDR: "10.200.0.0/16", ServiceCIDR: "10.101.0.0/16"},
+		Proxy: &config.Proxy{
+			HTTPProxy: "http://proxy.local:3128",
+			NoProxy:   []string{".internal", "localhost"},
+		},
+	}}
+	if err := ApplyProxy(target.New(root, false), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(root, "etc/environment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noProxy := "localhost,127.0.0.1,::1,169.254.169.254,169.254.0.0/16,.svc,.cluster.local,.internal," +
+		"node-1,192.168.1.10,fd00::10,10.0.0.5,10.100.0.0/16,10.101.0.0/16"
+	expected := `FOO="bar"
+HTTP_PROXY="http://proxy.local:3128"
+NO_PROXY="` + noProxy + `"
+http_proxy="http://proxy.local:3128"
+no_proxy="` + noProxy + `"
+`
+	if string(content) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, content)
+	}
+
+	vars := proxyVars(cfg)
+	if !reflect.DeepEqual(vars, []string{"HTTP_PROXY=http://proxy.local:3128",
+		"NO_PROXY=" + noProxy}) {
+		t.Errorf("unexpected k3s proxy variables %v", vars)
+	}
+
+	cfg.K3OS.Proxy = nil
+	cfg.K3OS.Environment = map[string]string{"no_proxy": "example.com"}
+	if err := ApplyProxy(target.New(root, false), cfg); err != nil {
+		t.Fatal(err)
+	}
+	content, err = ioutil.ReadFile(filepath.Join(root, "etc/environment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `FOO="bar"
+no_proxy="` + noProxy + `"
+`
+	if string(content) != expected {
+		t.Errorf("expected the proxy to be removed, got:\n%s", content)
+	}
+
+	cfg.K3OS.Environment = nil
+	if err := ApplyProxy(target.New(root, false), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyProxy(target.New(root, false), cfg); err == nil {
+		t.Error("expected the proxy to be skipped")
+	}
+}
@@ -0,0 +1,142 @@
+package cc
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+// RegistriesPath is where the k3os.registries section is written, k3s reads it when it starts
+const RegistriesPath = "/etc/rancher/k3s/registries.yaml"
+
+// registries is the format of registries.yaml
+type registries struct {
+	Mirrors map[string]registryMirror `json:"mirrors,omitempty"`
+	Configs map[string]registryConfig `json:"configs,omitempty"`
+}
+
+type registryMirror struct {
+	Endpoints []string          `json:"endpoint,omitempty"`
+	Rewrites  map[string]string `json:"rewrite,omitempty"`
+}
+
+type registryConfig struct {
+	Auth *registryAuth `json:"auth,omitempty"`
+	TLS  *registryTLS  `json:"tls,omitempty"`
+}
+
+type registryAuth struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identity_token,omitempty"`
+}
+
+type registryTLS struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// registriesConfig returns the registries.yaml of the k3os.registries section, or nil if there is none
+func registriesConfig(cfg *config.CloudConfig) ([]byte, error) {
+	r := cfg.K3OS.Registries
+	if r == nil || len(r.Mirrors) == 0 && len(r.Configs) == 0 {
+		return nil, nil
+	}
+
+	result := registries{}
+	for name, m := range r.Mirrors {
+		if result.Mirrors == nil {
+			result.Mirrors = map[string]registryMirror{}
+		}
+		result.Mirrors[name] = registryMirror{
+			Endpoints: m.Endpoints,
+			Rewrites:  m.Rewrites,
+		}
+	}
+	for name, c := range r.Configs {
+		if result.Configs == nil {
+			result.Configs = map[string]registryConfig{}
+		}
+		rc := registryConfig{}
+		if c.Auth != nil {
+			rc.Auth = &registryAuth{
+				Username:      c.Auth.Username,
+				Password:      c.Auth.Password,
+				Auth:          c.Auth.Auth,
+				IdentityToken: c.Auth.IdentityToken,
+			}
+		}
+		if c.TLS != nil {
+			rc.TLS = &registryTLS{
+				CAFile:             c.TLS.CAFile,
+				CertFile:           c.TLS.CertFile,
+				KeyFile:            c.TLS.KeyFile,
+				InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+			}
+		}
+		result.Configs[name] = rc
+	}
+
+	if len(cfg.CACerts) > 0 {
+		trustCACerts(&result)
+	}
+
+	return yaml.Marshal(result)
+}
+
+// trustCACerts makes containerd trust the certificates of caCerts for every registry and mirror endpoint without a
+// CA of its own, containerd adds them to the system certificates it read when k3s started
+func trustCACerts(r *registries) {
+	hosts := map[string]bool{}
+	for name := range r.Configs {
+		hosts[name] = true
+	}
+	for _, m := range r.Mirrors {
+		for _, e := range m.Endpoints {
+			if u, err := url.Parse(e); err == nil && u.Host != "" {
+				hosts[u.Host] = true
+			}
+		}
+	}
+	for host := range hosts {
+		if r.Configs == nil {
+			r.Configs = map[string]registryConfig{}
+		}
+		rc := r.Configs[host]
+		if rc.TLS == nil {
+			rc.TLS = &registryTLS{}
+		}
+		if rc.TLS.CAFile == "" {
+			rc.TLS.CAFile = CACertsPath
+		}
+		r.Configs[host] = rc
+	}
+}
+
+// ApplyRegistries writes registries.yaml, it holds the registry credentials so only root can read it.  Without the
+// k3os.registries section a file placed there another way is left alone.
+func ApplyRegistries(t *target.Target, cfg *config.CloudConfig) error {
+	content, err := registriesConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		return skip("no registries configured")
+	}
+
+	if err := t.MkdirAll(filepath.Dir(RegistriesPath), 0755); err != nil {
+		return err
+	}
+	t.Secret(RegistriesPath)
+	if err := t.WriteFileAtomic(RegistriesPath, content, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", RegistriesPath, err)
+	}
+	return nil
+}
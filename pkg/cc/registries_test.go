@@ -0,0 +1,42 @@
+package cc
+
+import (
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+)
+
+func TestRegistriesConfig(t *testing.T) {
+	cfg := &config.CloudConfig{}
+	cfg.K3OS.Registries = &config.Registries{
+		Mirrors: map[string]config.Mirror{
+			"docker.io": {Endpoints: []string{"https://mirror.local:5000"}},
+		},
+		Configs: map[string]config.RegistryConfig{
+			"mirror.local:5000": {
+				Auth: &config.RegistryAuth{Username: "me", Password: "secret"},
+				TLS:  &config.RegistryTLS{CAFile: "/etc/ssl/mirror.pem", InsecureSkipVerify: true},
+			},
+		},
+	}
+	expected := `configs:
+  mirror.local:5000:
+    auth:
+      password: secret
+      username: me
+    tls:
+      ca_file: /etc/ssl/mirror.pem
+      insecure_skip_verify: true
+mirrors:
+  docker.io:
+    endpoint:
+    - https://mirror.local:5000
+`
+	content, err := registriesConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", content, expected)
+	}
+}
@@ -0,0 +1,205 @@
+package cc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+// Applier is a named part of applying the configuration, such as the sysctls or the SSH keys
+type Applier struct {
+	Name string
+	// Phases maps the phases the applier runs in to the function applying it in that phase
+	Phases map[string]applier
+	// After are the appliers that run before this one in the phases both run in
+	After []string
+}
+
+// registry is in the order the appliers run when nothing else decides it
+var registry = []Applier{
+	{Name: "dataSource", Phases: in(ApplyDataSource, PhaseBoot)},
+	{Name: "modules", Phases: in(ApplyModules, PhaseInitrd, PhaseBoot, PhaseRuntime)},
+	{Name: "sysctls", Phases: in(ApplySysctls, PhaseInitrd, PhaseBoot), After: []string{"modules"}},
+	{Name: "hostname", Phases: in(ApplyHostname, PhaseInitrd, PhaseBoot)},
+	{Name: "disks", Phases: in(ApplyDisks, PhaseBoot), After: []string{"modules"}},
+	{Name: "mounts", Phases: in(ApplyMounts, PhaseBoot), After: []string{"modules", "disks"}},
+	{Name: "swap", Phases: in(ApplySwap, PhaseBoot), After: []string{"modules", "mounts"}},
+	{Name: "dns", Phases: in(ApplyDNS, PhaseBoot)},
+	{Name: "wifi", Phases: in(ApplyWifi, PhaseBoot)},
+	{Name: "network", Phases: in(ApplyNetwork, PhaseBoot, PhaseRuntime), After: []string{"modules"}},
+	{
+		Name: "time",
+		Phases: map[string]applier{
+			PhaseBoot:    ApplyTime,
+			PhaseRuntime: ApplyTimeWithSync,
+		},
+		After: []string{"dns", "network"},
+	},
+	{Name: "proxy", Phases: in(ApplyProxy, PhaseBoot, PhaseInstall, PhaseRuntime)},
+	{Name: "caCerts", Phases: in(ApplyCACerts, PhaseBoot, PhaseInstall, PhaseRuntime)},
+	{
+		Name: "users",
+		Phases: map[string]applier{
+			PhaseBoot:    ApplyUsers,
+			PhaseRuntime: ApplyUsersWithNet,
+		},
+	},
+	{Name: "password", Phases: in(ApplyPassword, PhaseBoot), After: []string{"users"}},
+	{
+		Name: "ssh",
+		Phases: map[string]applier{
+			PhaseBoot:    ApplySSHKeys,
+			PhaseRuntime: ApplySSHKeysWithNet,
+		},
+		After: []string{"users"},
+	},
+	{
+		Name:   "writeFiles",
+		Phases: in(ApplyWriteFiles, PhaseInitrd, PhaseBoot, PhaseRuntime),
+		After:  []string{"users", "password", "ssh"},
+	},
+	{Name: "environment", Phases: in(ApplyEnvironment, PhaseInitrd, PhaseBoot, PhaseRuntime)},
+	{Name: "runCmd", Phases: in(ApplyRuncmd, PhaseRuntime), After: []string{"writeFiles", "environment"}},
+	{Name: "install", Phases: in(ApplyInstall, PhaseRuntime), After: []string{"runCmd"}},
+	{Name: "manifests", Phases: in(ApplyManifests, PhaseBoot, PhaseRuntime), After: []string{"writeFiles"}},
+	{Name: "images", Phases: in(ApplyImages, PhaseBoot, PhaseRuntime), After: []string{"writeFiles"}},
+	{Name: "registries", Phases: in(ApplyRegistries, PhaseBoot, PhaseInstall, PhaseRuntime), After: []string{"caCerts"}},
+	{
+		Name: "k3s",
+		Phases: map[string]applier{
+			PhaseBoot:    ApplyK3SNoRestart,
+			PhaseInstall: ApplyK3SWithRestart,
+			PhaseRuntime: ApplyK3SInstall,
+		},
+		After: []string{"writeFiles", "environment", "runCmd", "proxy", "caCerts", "registries", "images", "mounts", "swap", "time"},
+	},
+	{Name: "imageCheck", Phases: in(ApplyImageCheck, PhaseRuntime), After: []string{"k3s"}},
+	{Name: "bootCmd", Phases: in(ApplyBootcmd, PhaseBoot), After: []string{"writeFiles", "environment", "k3s"}},
+	{Name: "initCmd", Phases: in(ApplyInitcmd, PhaseInitrd), After: []string{"writeFiles", "environment"}},
+}
+
+// in returns the phases map of an applier that does the same in every phase
+func in(apply applier, phases ...string) map[string]applier {
+	result := map[string]applier{}
+	for _, phase := range phases {
+		result[phase] = apply
+	}
+	return result
+}
+
+// Appliers returns the names of all the appliers
+func Appliers() []string {
+	var names []string
+	for _, a := range registry {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// Selection limits which appliers run, by name.  With Only set nothing else runs; the appliers in Skip never run.
+type Selection struct {
+	Only []string
+	Skip []string
+}
+
+func (s Selection) validate() error {
+	known := map[string]bool{}
+	for _, a := range registry {
+		known[a.Name] = true
+	}
+	var unknown []string
+	for _, name := range append(append([]string{}, s.Only...), s.Skip...) {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown applier(s) %s, expected one of %s", strings.Join(unknown, ", "),
+			strings.Join(Appliers(), ", "))
+	}
+	return nil
+}
+
+func (s Selection) selected(name string) bool {
+	for _, skipped := range s.Skip {
+		if skipped == name {
+			return false
+		}
+	}
+	if len(s.Only) == 0 {
+		return true
+	}
+	for _, only := range s.Only {
+		if only == name {
+			return true
+		}
+	}
+	return false
+}
+
+// steps returns the selected appliers of a phase, each after the appliers it depends on
+func steps(phase string, sel Selection) ([]step, error) {
+	if err := sel.validate(); err != nil {
+		return nil, err
+	}
+
+	index := map[string]int{}
+	var candidates []Applier
+	for _, a := range registry {
+		if _, ok := a.Phases[phase]; ok && sel.selected(a.Name) {
+			index[a.Name] = len(candidates)
+			candidates = append(candidates, a)
+		}
+	}
+
+	// dependencies on appliers that are not run in this phase do not hold anything back
+	waiting := make([]int, len(candidates))
+	next := map[string][]int{}
+	for i, a := range candidates {
+		for _, dep := range a.After {
+			if _, ok := index[dep]; ok {
+				waiting[i]++
+				next[dep] = append(next[dep], i)
+			}
+		}
+	}
+
+	var (
+		result []step
+		ready  []int
+	)
+	for i := range candidates {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		// the earliest registered first, so the order only changes where a dependency requires it
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		result = append(result, step{name: candidates[i].Name, apply: candidates[i].Phases[phase]})
+		for _, j := range next[candidates[i].Name] {
+			waiting[j]--
+			if waiting[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if len(result) != len(candidates) {
+		return nil, fmt.Errorf("the appliers of the %s phase depend on each other in a cycle", phase)
+	}
+	return result, nil
+}
+
+// Apply runs the selected appliers of a phase
+func Apply(t *target.Target, cfg *config.CloudConfig, phase string, sel Selection) (*Report, error) {
+	s, err := steps(phase, sel)
+	if err != nil {
+		return nil, err
+	}
+	return runApplies(t, cfg, phase, s...)
+}
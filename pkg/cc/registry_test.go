@@ -0,0 +1,70 @@
+package cc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func names(s []step) []string {
+	var result []string
+	for _, step := range s {
+		result = append(result, step.name)
+	}
+	return result
+}
+
+func TestSteps(t *testing.T) {
+	tests := []struct {
+		phase    string
+		sel      Selection
+		expected []string
+	}{
+		{PhaseInitrd, Selection{}, []string{"modules", "sysctls", "hostname", "writeFiles", "environment", "initCmd"}},
+		{PhaseBoot, Selection{}, []string{"dataSource", "modules", "sysctls", "hostname", "disks", "mounts", "swap", "dns", "wifi", "network", "time", "proxy", "caCerts", "users",
+			"password", "ssh", "writeFiles", "environment", "manifests", "images", "registries", "k3s", "bootCmd"}},
+		{PhaseInstall, Selection{}, []string{"proxy", "caCerts", "registries", "k3s"}},
+		{PhaseRuntime, Selection{}, []string{"modules", "network", "time", "proxy", "caCerts", "users", "ssh", "writeFiles", "environment", "runCmd", "install",
+			"manifests", "images", "registries", "k3s", "imageCheck"}},
+		{PhaseBoot, Selection{Only: []string{"ssh", "sysctls"}}, []string{"sysctls", "ssh"}},
+		{PhaseRuntime, Selection{Only: []string{"k3s", "ssh", "modules"}, Skip: []string{"modules"}},
+			[]string{"ssh", "k3s"}},
+		{PhaseInstall, Selection{Only: []string{"sysctls"}}, nil},
+	}
+
+	for _, test := range tests {
+		s, err := steps(test.phase, test.sel)
+		if err != nil {
+			t.Errorf("%s %+v: %v", test.phase, test.sel, err)
+			continue
+		}
+		if got := names(s); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s %+v: expected %v, got %v", test.phase, test.sel, test.expected, got)
+		}
+	}
+
+	if _, err := steps(PhaseBoot, Selection{Only: []string{"sysctl"}}); err == nil {
+		t.Error("expected an error for an unknown applier")
+	}
+}
+
+func TestRegistryDependencies(t *testing.T) {
+	known := map[string]bool{}
+	for _, a := range registry {
+		if known[a.Name] {
+			t.Errorf("%s is registered twice", a.Name)
+		}
+		known[a.Name] = true
+	}
+	for _, a := range registry {
+		for _, dep := range a.After {
+			if !known[dep] {
+				t.Errorf("%s depends on unknown applier %s", a.Name, dep)
+			}
+		}
+	}
+	for _, phase := range Phases {
+		if _, err := steps(phase, Selection{}); err != nil {
+			t.Error(err)
+		}
+	}
+}
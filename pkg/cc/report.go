@@ -0,0 +1,235 @@
+package cc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3os/pkg/system"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+// The phases configuration is applied in
+const (
+	PhaseInitrd  = "initrd"
+	PhaseBoot    = "boot"
+	PhaseInstall = "install"
+	PhaseRuntime = "runtime"
+)
+
+// Phases are all the phases, in the order they run
+var Phases = []string{PhaseInitrd, PhaseBoot, PhaseInstall, PhaseRuntime}
+
+// HistoryDir is where the reports of earlier runs are kept, the last historySize of each phase
+var HistoryDir = system.LocalPath("apply-history")
+
+const historySize = 20
+
+// Result is the outcome of one applier
+type Result struct {
+	Name     string        `json:"name"`
+	Changed  bool          `json:"changed,omitempty"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Failed   bool          `json:"failed,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Message  string        `json:"message,omitempty"`
+	Changes  []string      `json:"changes,omitempty"`
+}
+
+// Status is a short description of the result: ok, changed, skipped or failed
+func (r Result) Status() string {
+	switch {
+	case r.Failed:
+		return "failed"
+	case r.Skipped:
+		return "skipped"
+	case r.Changed:
+		return "changed"
+	}
+	return "ok"
+}
+
+// Report is the outcome of applying the configuration for a phase
+type Report struct {
+	Phase    string        `json:"phase"`
+	Root     string        `json:"root,omitempty"`
+	DryRun   bool          `json:"dryRun,omitempty"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Results  []Result      `json:"results"`
+}
+
+// Failed returns the number of appliers that failed
+func (r *Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if result.Failed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// skipError is returned by an applier that has nothing to do
+type skipError struct {
+	reason string
+}
+
+func (s skipError) Error() string {
+	return s.reason
+}
+
+func skip(format string, args ...interface{}) error {
+	return skipError{reason: fmt.Sprintf(format, args...)}
+}
+
+// noteError is returned by an applier that succeeded and has something to report, such as the state it left behind
+type noteError struct {
+	note string
+}
+
+func (n noteError) Error() string {
+	return n.note
+}
+
+func note(format string, args ...interface{}) error {
+	return noteError{note: fmt.Sprintf(format, args...)}
+}
+
+// ReportPath is where the report of the last run of a phase is saved
+func ReportPath(phase string) string {
+	return system.StatePath(fmt.Sprintf("apply-%s.json", phase))
+}
+
+// ReadReport returns the report of the last run of a phase, or nil if it has not run since boot
+func ReadReport(phase string) (*Report, error) {
+	return readReport(ReportPath(phase))
+}
+
+// History returns the saved reports of a phase, newest first
+func History(phase string) ([]*Report, error) {
+	paths, err := historyFiles(phase)
+	if err != nil {
+		return nil, err
+	}
+	var result []*Report
+	for i := len(paths) - 1; i >= 0; i-- {
+		report, err := readReport(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		if report != nil {
+			result = append(result, report)
+		}
+	}
+	return result, nil
+}
+
+func readReport(path string) (*Report, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	if err := json.Unmarshal(bytes, report); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return report, nil
+}
+
+// historyFiles returns the saved reports of a phase, oldest first
+func historyFiles(phase string) ([]string, error) {
+	// Glob skips a directory it can not read, which would look like no history at all
+	if f, err := os.Open(HistoryDir); os.IsPermission(err) {
+		return nil, fmt.Errorf("can not read the history in %s, run as root: %v", HistoryDir, err)
+	} else if err == nil {
+		f.Close()
+	}
+	paths, err := filepath.Glob(filepath.Join(HistoryDir, phase+"-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// save writes the report to ReportPath and HistoryDir of the target, the oldest history is removed
+func (r *Report) save(t *target.Target) error {
+	bytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	reportPath := t.Path(ReportPath(r.Phase))
+	if err := os.MkdirAll(filepath.Dir(reportPath), 0755); err != nil {
+		return err
+	}
+	if err := util.WriteFileAtomic(reportPath, bytes, 0644); err != nil {
+		return err
+	}
+
+	// the history is readable by everyone, so `config status --history` works without root, and is kept on disk, so
+	// it only holds the status of each applier and not the messages and changes, which name the commands run
+	if bytes, err = json.MarshalIndent(r.redacted(), "", "  "); err != nil {
+		return err
+	}
+	historyDir := t.Path(HistoryDir)
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return err
+	}
+	if err := os.Chmod(historyDir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.json", r.Phase, r.Started.UTC().Format("20060102T150405.000"))
+	if err := util.WriteFileAtomic(filepath.Join(historyDir, name), bytes, 0644); err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(historyDir, r.Phase+"-*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for len(paths) > historySize {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	// the history of older releases was only readable by root
+	for _, p := range paths {
+		if err := os.Chmod(p, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redacted returns the report without the messages and changes of the appliers
+func (r *Report) redacted() *Report {
+	result := *r
+	result.Results = make([]Result, len(r.Results))
+	for i, res := range r.Results {
+		res.Message = ""
+		res.Changes = nil
+		result.Results[i] = res
+	}
+	return &result
+}
+
+// summary returns the changes made by an applier in one line
+func summary(changes []string) string {
+	const max = 3
+	if len(changes) > max {
+		return fmt.Sprintf("%s and %d more", strings.Join(changes[:max], ", "), len(changes)-max)
+	}
+	return strings.Join(changes, ", ")
+}
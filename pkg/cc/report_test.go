@@ -0,0 +1,86 @@
+package cc
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestRunApplies(t *testing.T) {
+	root, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	tgt := target.New(root, false)
+	write := func(t *target.Target, cfg *config.CloudConfig) error {
+		return t.WriteFile("/hostname", []byte("test\n"), 0644)
+	}
+	report, err := runApplies(tgt, &config.CloudConfig{}, PhaseBoot,
+		step{"write", write},
+		step{"skip", func(t *target.Target, cfg *config.CloudConfig) error { return skip("nothing to do") }},
+		step{"fail", func(t *target.Target, cfg *config.CloudConfig) error { return errors.New("broken") }},
+		step{"same", write},
+		step{"note", func(t *target.Target, cfg *config.CloudConfig) error {
+			if err := t.WriteFile("/timezone", []byte("UTC\n"), 0644); err != nil {
+				return err
+			}
+			return note("in sync")
+		}},
+	)
+	if err == nil {
+		t.Fatal("expected the failed step to be returned")
+	}
+
+	expected := []string{"changed", "skipped", "failed", "ok", "changed"}
+	for i, result := range report.Results {
+		if result.Status() != expected[i] {
+			t.Errorf("%s: expected %s, got %s", result.Name, expected[i], result.Status())
+		}
+	}
+	if report.Results[0].Message != "wrote /hostname" {
+		t.Errorf("unexpected message %q", report.Results[0].Message)
+	}
+	if report.Results[4].Message != "in sync; wrote /timezone" {
+		t.Errorf("unexpected message %q", report.Results[4].Message)
+	}
+	if report.Failed() != 1 {
+		t.Errorf("expected 1 failure, got %d", report.Failed())
+	}
+
+	saved, err := readReport(tgt.Path(ReportPath(PhaseBoot)))
+	if err != nil || saved == nil {
+		t.Fatalf("report was not saved: %v", err)
+	}
+	if saved.Root != root || len(saved.Results) != 5 {
+		t.Errorf("unexpected saved report %+v", saved)
+	}
+
+	history, err := filepath.Glob(filepath.Join(tgt.Path(HistoryDir), PhaseBoot+"-*.json"))
+	if err != nil || len(history) != 1 {
+		t.Fatalf("expected 1 history file, got %v %v", history, err)
+	}
+	// readable without root, as the last report
+	if info, err := os.Stat(history[0]); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("unexpected history file mode: %v %v", info, err)
+	}
+	if info, err := os.Stat(tgt.Path(HistoryDir)); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("unexpected history directory mode: %v %v", info, err)
+	}
+	// so it does not hold the messages and changes, which name the commands run
+	old, err := readReport(history[0])
+	if err != nil || old == nil || len(old.Results) != 5 {
+		t.Fatalf("unexpected history %+v %v", old, err)
+	}
+	for i, result := range old.Results {
+		if result.Message != "" || len(result.Changes) != 0 || result.Status() != expected[i] {
+			t.Errorf("unexpected history result %+v", result)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package cc
+
+import (
+	"strings"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/swap"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func ApplySwap(t *target.Target, cfg *config.CloudConfig) error {
+	if cfg.K3OS.Swap == nil {
+		return skip("no swap configured")
+	}
+	if !t.IsLive() {
+		return skip("swap is only set up on the running system")
+	}
+	return swap.Configure(t, cfg.K3OS.Swap)
+}
+
+// swapKubeletArgs returns the k3s args letting the kubelet start with swap, unless the kubelet is configured to fail
+// with swap already
+func swapKubeletArgs(cfg *config.CloudConfig) []string {
+	s := cfg.K3OS.Swap
+	if s == nil || s.Zram == nil && s.File == "" {
+		return nil
+	}
+
+	var kubeletArgs []string
+	if cfg.K3OS.K3S != nil {
+		kubeletArgs = append(kubeletArgs, cfg.K3OS.K3S.KubeletArgs...)
+	}
+	args := cfg.K3OS.K3sArgs
+	for i, arg := range args {
+		if arg == "--kubelet-arg" && i+1 < len(args) {
+			kubeletArgs = append(kubeletArgs, args[i+1])
+		} else if strings.HasPrefix(arg, "--kubelet-arg=") {
+			kubeletArgs = append(kubeletArgs, strings.TrimPrefix(arg, "--kubelet-arg="))
+		}
+	}
+	for _, arg := range kubeletArgs {
+		if strings.HasPrefix(strings.TrimLeft(arg, "-"), "fail-swap-on") {
+			return nil
+		}
+	}
+	return []string{"--kubelet-arg", "fail-swap-on=false"}
+}
@@ -0,0 +1,31 @@
+package cc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+)
+
+func TestSwapKubeletArgs(t *testing.T) {
+	cfg := &config.CloudConfig{}
+	if args := swapKubeletArgs(cfg); args != nil {
+		t.Errorf("expected no args without swap, got %v", args)
+	}
+
+	cfg.K3OS.Swap = &config.Swap{Zram: &config.Zram{}}
+	if args := swapKubeletArgs(cfg); !reflect.DeepEqual(args, []string{"--kubelet-arg", "fail-swap-on=false"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	cfg.K3OS.K3sArgs = []string{"server", "--kubelet-arg=fail-swap-on=true"}
+	if args := swapKubeletArgs(cfg); args != nil {
+		t.Errorf("expected the configured kubelet arg to win, got %v", args)
+	}
+
+	cfg.K3OS.K3sArgs = nil
+	cfg.K3OS.K3S = &config.K3S{KubeletArgs: []string{"fail-swap-on=true"}}
+	if args := swapKubeletArgs(cfg); args != nil {
+		t.Errorf("expected the configured kubelet arg to win, got %v", args)
+	}
+}
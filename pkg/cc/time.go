@@ -0,0 +1,28 @@
+package cc
+
+import (
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/timesync"
+)
+
+// ApplyTime configures the time sync at boot, before the network is up
+func ApplyTime(t *target.Target, cfg *config.CloudConfig) error {
+	return applyTime(t, cfg, false)
+}
+
+// ApplyTimeWithSync configures the time sync and syncs the clock
+func ApplyTimeWithSync(t *target.Target, cfg *config.CloudConfig) error {
+	return applyTime(t, cfg, true)
+}
+
+func applyTime(t *target.Target, cfg *config.CloudConfig, sync bool) error {
+	if cfg.K3OS.Time == nil {
+		return skip("no time sync configured")
+	}
+	status, err := timesync.Configure(t, cfg.K3OS.Time, cfg.K3OS.NTPServers, sync)
+	if err != nil {
+		return err
+	}
+	return note("%s", status)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/rancher/k3os/pkg/cli/config"
+	"github.com/rancher/k3os/pkg/cli/datasource"
 	"github.com/rancher/k3os/pkg/cli/install"
 	"github.com/rancher/k3os/pkg/cli/rc"
 	"github.com/rancher/k3os/pkg/cli/upgrade"
@@ -38,6 +39,7 @@ func New() *cli.App {
 	app.Commands = []cli.Command{
 		rc.Command(),
 		config.Command(),
+		datasource.Command(),
 		install.Command(),
 		upgrade.Command(),
 	}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/rancher/k3os/pkg/cc"
 	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -17,6 +19,17 @@ var (
 	installPhase = false
 	dump         = false
 	dumpJSON     = false
+	explain      = false
+	explainJSON  = false
+	validate     = false
+	jsonSchema   = false
+	facts        = false
+	showSecrets  = false
+	root         = "/"
+	dryRun       = false
+	force        = false
+	only         = cli.StringSlice{}
+	skip         = cli.StringSlice{}
 )
 
 // Command `config`
@@ -44,6 +57,32 @@ func Command() cli.Command {
 				Destination: &installPhase,
 				Usage:       "Run install stage",
 			},
+			cli.StringFlag{
+				Name:        "root",
+				Value:       root,
+				Destination: &root,
+				Usage:       "Apply the configuration to the system mounted at this directory",
+			},
+			cli.BoolFlag{
+				Name:        "dry-run",
+				Destination: &dryRun,
+				Usage:       "Print the changes to files and the commands to run instead of applying them",
+			},
+			cli.BoolFlag{
+				Name:        "force",
+				Destination: &force,
+				Usage:       "Reinstall and restart k3s even if its configuration did not change",
+			},
+			cli.StringSliceFlag{
+				Name:  "only",
+				Value: &only,
+				Usage: "Only run these appliers, comma separated, such as sysctls,ssh",
+			},
+			cli.StringSliceFlag{
+				Name:  "skip",
+				Value: &skip,
+				Usage: "Do not run these appliers, comma separated, such as k3s",
+			},
 			cli.BoolFlag{
 				Name:        "dump",
 				Destination: &dump,
@@ -54,14 +93,58 @@ func Command() cli.Command {
 				Destination: &dumpJSON,
 				Usage:       "Print current configuration in json",
 			},
+			cli.BoolFlag{
+				Name:        "show-secrets",
+				Destination: &showSecrets,
+				Usage:       "Print secret values with --dump, --explain and their json forms instead of redacting them",
+			},
+			cli.BoolFlag{
+				Name:        "explain",
+				Destination: &explain,
+				Usage:       "Print current configuration annotated with the source of each value",
+			},
+			cli.BoolFlag{
+				Name:        "explain-json",
+				Destination: &explainJSON,
+				Usage:       "Print the source of each configuration value in json",
+			},
+			cli.BoolFlag{
+				Name:        "validate",
+				Destination: &validate,
+				Usage:       "Validate the configuration, or only the given files, and exit non-zero on problems",
+			},
+			cli.BoolFlag{
+				Name:        "json-schema",
+				Destination: &jsonSchema,
+				Usage:       "Print the JSON Schema of the configuration file",
+			},
+			cli.BoolFlag{
+				Name:        "facts",
+				Destination: &facts,
+				Usage:       "Print the node facts available to configuration templates",
+			},
+		},
+		Subcommands: []cli.Command{
+			sealCommand(),
+			migrateCommand(),
+			statusCommand(),
 		},
 		Before: func(c *cli.Context) error {
+			if validate || jsonSchema || dryRun || c.Args().First() == "seal" || c.Args().First() == "status" {
+				return nil
+			}
 			if os.Getuid() != 0 {
 				return fmt.Errorf("must be run as root")
 			}
 			return nil
 		},
-		Action: func(*cli.Context) {
+		Action: func(c *cli.Context) {
+			if validate {
+				if err := Validate(c.Args()...); err != nil {
+					logrus.Fatal(err)
+				}
+				return
+			}
 			if err := Main(); err != nil {
 				logrus.Error(err)
 			}
@@ -69,24 +152,93 @@ func Command() cli.Command {
 	}
 }
 
+// Validate `config --validate`
+func Validate(files ...string) error {
+	var errs []config.ValidationError
+	if len(files) > 0 {
+		errs = config.ValidateFiles(files...)
+	} else {
+		errs = config.Validate()
+	}
+	for _, err := range errs {
+		fmt.Println(err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("configuration has %d problem(s)", len(errs))
+	}
+	return nil
+}
+
 // Main `config`
 func Main() error {
+	if jsonSchema {
+		bytes, err := config.JSONSchema()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(bytes))
+		return err
+	}
+
+	if facts {
+		f, err := config.GetFacts()
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(os.Stdout).Encode(f)
+	}
+
+	if explain || explainJSON {
+		origins, err := config.Explain()
+		if err != nil {
+			return err
+		}
+		if !showSecrets {
+			origins = config.RedactOrigins(origins)
+		}
+		if explainJSON {
+			return json.NewEncoder(os.Stdout).Encode(origins)
+		}
+		return config.WriteExplain(origins, os.Stdout)
+	}
+
+	if dump || dumpJSON {
+		return config.Dump(os.Stdout, dumpJSON, showSecrets)
+	}
+
 	cfg, err := config.ReadConfig()
 	if err != nil {
 		return err
 	}
 
+	phase := cc.PhaseRuntime
 	if initrd {
-		return cc.InitApply(&cfg)
+		phase = cc.PhaseInitrd
 	} else if bootPhase {
-		return cc.BootApply(&cfg)
+		phase = cc.PhaseBoot
 	} else if installPhase {
-		return cc.InstallApply(&cfg)
-	} else if dump {
-		return config.Write(cfg, os.Stdout)
-	} else if dumpJSON {
-		return json.NewEncoder(os.Stdout).Encode(&cfg)
+		phase = cc.PhaseInstall
 	}
 
-	return cc.RunApply(&cfg)
+	sel := cc.Selection{
+		Only: splitNames(only),
+		Skip: splitNames(skip),
+	}
+	t := target.New(root, dryRun)
+	t.Force = force
+	_, err = cc.Apply(t, &cfg, phase, sel)
+	return err
+}
+
+// splitNames returns the names given to a flag, which may be repeated or comma separated
+func splitNames(values []string) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
 }
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func migrateCommand() cli.Command {
+	return cli.Command{
+		Name:  "migrate",
+		Usage: "rewrite the local configuration files in the current format",
+		Action: func(c *cli.Context) {
+			if err := Migrate(); err != nil {
+				logrus.Fatal(err)
+			}
+		},
+	}
+}
+
+// Migrate `config migrate`
+func Migrate() error {
+	failed := 0
+	for _, result := range config.Migrate() {
+		if result.Error != "" {
+			fmt.Printf("%s: %s\n", result.Path, result.Error)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: migrated to %s, original saved to %s\n", result.Path, config.APIVersion, result.Backup)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to migrate %d file(s)", failed)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var (
+	publicKey      = ""
+	printPublicKey = false
+)
+
+func sealCommand() cli.Command {
+	return cli.Command{
+		Name:      "seal",
+		Usage:     "encrypt a value for the configuration of a node",
+		ArgsUsage: "[VALUE]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:        "public-key",
+				Destination: &publicKey,
+				Usage:       "Public key of the node to encrypt for, defaults to the key of this node",
+			},
+			cli.BoolFlag{
+				Name:        "print-public-key",
+				Destination: &printPublicKey,
+				Usage:       "Print the public key of this node, generating it if needed",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := Seal(c.Args().First()); err != nil {
+				logrus.Fatal(err)
+			}
+		},
+	}
+}
+
+// Seal `config seal`, without a value it is read from stdin
+func Seal(value string) error {
+	if printPublicKey || publicKey == "" {
+		if os.Getuid() != 0 {
+			return fmt.Errorf("must be run as root to use the key of this node, or pass --public-key")
+		}
+		key, err := config.PublicKey()
+		if err != nil {
+			return err
+		}
+		if printPublicKey {
+			fmt.Println(key)
+			return nil
+		}
+		publicKey = key
+	}
+
+	if value == "" {
+		bytes, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(string(bytes), "\n")
+	}
+
+	sealed, err := config.Seal(value, publicKey)
+	if err != nil {
+		return err
+	}
+	fmt.Println(sealed)
+	return nil
+}
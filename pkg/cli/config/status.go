@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/k3os/pkg/cc"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var (
+	statusJSON = false
+	history    = false
+)
+
+func statusCommand() cli.Command {
+	return cli.Command{
+		Name:      "status",
+		Usage:     "show the result of the last time the configuration was applied",
+		ArgsUsage: "[PHASE...]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:        "json",
+				Destination: &statusJSON,
+				Usage:       "Print the reports in json",
+			},
+			cli.BoolFlag{
+				Name:        "history",
+				Destination: &history,
+				Usage:       "Print the earlier runs too, newest first",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := Status(c.Args()...); err != nil {
+				logrus.Fatal(err)
+			}
+		},
+	}
+}
+
+// Status `config status`, without phases every phase is shown
+func Status(phases ...string) error {
+	if len(phases) == 0 {
+		phases = cc.Phases
+	}
+
+	var reports []*cc.Report
+	for _, phase := range phases {
+		if history {
+			h, err := cc.History(phase)
+			if err != nil {
+				return err
+			}
+			reports = append(reports, h...)
+			continue
+		}
+		report, err := cc.ReadReport(phase)
+		if err != nil {
+			return err
+		}
+		if report != nil {
+			reports = append(reports, report)
+		}
+	}
+
+	if statusJSON {
+		return json.NewEncoder(os.Stdout).Encode(reports)
+	}
+
+	failed := 0
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Println()
+		}
+		printReport(report)
+		if !history {
+			failed += report.Failed()
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d applier(s) failed", failed)
+	}
+	return nil
+}
+
+func printReport(report *cc.Report) {
+	fmt.Printf("Phase %s, applied %s in %s", report.Phase, report.Started.Local().Format(time.RFC3339),
+		report.Duration.Round(time.Millisecond))
+	if report.Root != "" {
+		fmt.Printf(" to %s", report.Root)
+	}
+	if failed := report.Failed(); failed > 0 {
+		fmt.Printf(", %d failed", failed)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tDURATION\tMESSAGE")
+	for _, result := range report.Results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Name, result.Status(), result.Duration.Round(time.Millisecond),
+			result.Message)
+	}
+	w.Flush()
+}
@@ -0,0 +1,94 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/datasource"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var (
+	timeout   = datasource.DefaultTimeout
+	outputDir = datasource.ConfigDir
+	dump      = false
+)
+
+// Command is the `datasource` sub-command, it fetches the instance configuration from cloud metadata.
+func Command() cli.Command {
+	return cli.Command{
+		Name:      "datasource",
+		Usage:     "fetch configuration from cloud metadata",
+		ArgsUsage: "[DATASOURCE...]",
+		Flags: []cli.Flag{
+			cli.DurationFlag{
+				Name:        "timeout",
+				Value:       timeout,
+				Usage:       "time to wait for each data source",
+				Destination: &timeout,
+			},
+			cli.StringFlag{
+				Name:        "output",
+				Value:       outputDir,
+				Usage:       "directory to write the configuration to",
+				Destination: &outputDir,
+			},
+			cli.BoolFlag{
+				Name:        "dump",
+				Usage:       "print the configuration instead of writing it",
+				Destination: &dump,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if os.Getuid() != 0 {
+				return fmt.Errorf("must be run as root")
+			}
+			return nil
+		},
+		Action: func(c *cli.Context) {
+			if err := Run(c.Args()...); err != nil {
+				logrus.Fatal(err)
+			}
+		},
+	}
+}
+
+// Run the `datasource` sub-command, without names the `k3os.data_sources` from the configuration are used
+func Run(names ...string) error {
+	if len(names) == 0 {
+		cfg, err := config.ReadConfig()
+		if err != nil {
+			return err
+		}
+		names = cfg.K3OS.DataSources
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no data sources configured")
+	}
+
+	providers, err := datasource.Get(names...)
+	if err != nil {
+		return err
+	}
+
+	data, err := datasource.Fetch(context.Background(), timeout, providers...)
+	if err == datasource.ErrNotFound {
+		logrus.Warnf("no data source found in %v", names)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if dump {
+		cfg, err := data.CloudConfig()
+		if err != nil {
+			return err
+		}
+		return config.WriteRedacted(cfg, os.Stdout)
+	}
+
+	return datasource.Write(data, outputDir)
+}
@@ -201,7 +201,7 @@ func AskPassword(cfg *config.CloudConfig) error {
 	}()
 
 	cmd := exec.Command("chpasswd")
-	cmd.Stdin = strings.NewReader(fmt.Sprintf("rancher:%s", pass))
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s:%s", config.DefaultUser, pass))
 	errBuffer := &bytes.Buffer{}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = errBuffer
@@ -220,7 +220,7 @@ func AskPassword(cfg *config.CloudConfig) error {
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		fields := strings.Split(scanner.Text(), ":")
-		if len(fields) > 1 && fields[0] == "rancher" {
+		if len(fields) > 1 && fields[0] == config.DefaultUser {
 			cfg.K3OS.Password = fields[1]
 			return nil
 		}
@@ -7,23 +7,25 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/rancher/k3os/pkg/target"
 	"github.com/sirupsen/logrus"
 )
 
-func ExecuteCommand(commands []string) error {
+func ExecuteCommand(t *target.Target, commands []string) error {
 	for _, cmd := range commands {
 		logrus.Debugf("running cmd `%s`", cmd)
 		c := exec.Command("sh", "-c", cmd)
 		c.Stdout = os.Stdout
 		c.Stderr = os.Stderr
-		if err := c.Run(); err != nil {
+		if err := t.Run(c); err != nil {
 			return fmt.Errorf("failed to run %s: %v", cmd, err)
 		}
 	}
 	return nil
 }
 
-func SetPassword(password string) error {
+// SetPassword sets the password of the user, it may be hashed already
+func SetPassword(t *target.Target, user, password string) error {
 	if password == "" {
 		return nil
 	}
@@ -31,11 +33,11 @@ func SetPassword(password string) error {
 	if strings.HasPrefix(password, "$") {
 		cmd.Args = append(cmd.Args, "-e")
 	}
-	cmd.Stdin = strings.NewReader(fmt.Sprint("rancher:", password))
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s:%s", user, password))
 	cmd.Stdout = os.Stdout
 	errBuffer := &bytes.Buffer{}
 	cmd.Stderr = errBuffer
-	err := cmd.Run()
+	err := t.Run(cmd)
 	if err != nil {
 		os.Stderr.Write(errBuffer.Bytes())
 	}
@@ -58,6 +58,10 @@ func NewToMap() mapper.Mapper {
 		if m, ok := val.(map[string]interface{}); ok {
 			obj := make(map[string]string, len(m))
 			for k, v := range m {
+				if _, _, ok := reference(v); ok {
+					// references are resolved after the merge
+					return val
+				}
 				obj[k] = convert.ToString(v)
 			}
 			return obj
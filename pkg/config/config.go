@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type K3OS struct {
@@ -13,19 +15,212 @@ type K3OS struct {
 	NTPServers     []string          `json:"ntpServers,omitempty"`
 	DNSNameservers []string          `json:"dnsNameservers,omitempty"`
 	Wifi           []Wifi            `json:"wifi,omitempty"`
-	Password       string            `json:"password,omitempty"`
+	Password       string            `json:"password,omitempty" secret:"true"`
 	ServerURL      string            `json:"serverUrl,omitempty"`
-	Token          string            `json:"token,omitempty"`
+	Token          string            `json:"token,omitempty" secret:"true"`
 	Labels         map[string]string `json:"labels,omitempty"`
 	K3sArgs        []string          `json:"k3sArgs,omitempty"`
 	Environment    map[string]string `json:"environment,omitempty"`
 	Taints         []string          `json:"taints,omitempty"`
+	K3S            *K3S              `json:"k3s,omitempty"`
+	Registries     *Registries       `json:"registries,omitempty"`
+	Manifests      []Manifest        `json:"manifests,omitempty"`
+	HelmCharts     []HelmChart       `json:"helmCharts,omitempty"`
+	Images         *Images           `json:"images,omitempty"`
+	Network        *Network          `json:"network,omitempty"`
+	Proxy          *Proxy            `json:"proxy,omitempty"`
+	Swap           *Swap             `json:"swap,omitempty"`
+	Time           *TimeSync         `json:"time,omitempty"`
 	Install        *Install          `json:"install,omitempty"`
 }
 
+// K3S is written to the k3s config.yaml, the fields only valid for servers are left out on agents
+type K3S struct {
+	NodeName       string   `json:"nodeName,omitempty"`
+	NodeIP         string   `json:"nodeIp,omitempty"`
+	NodeExternalIP string   `json:"nodeExternalIp,omitempty"`
+	ClusterCIDR    string   `json:"clusterCidr,omitempty"`
+	ServiceCIDR    string   `json:"serviceCidr,omitempty"`
+	ClusterDNS     string   `json:"clusterDns,omitempty"`
+	FlannelBackend string   `json:"flannelBackend,omitempty"`
+	Disable        []string `json:"disable,omitempty"`
+	TLSSAN         []string `json:"tlsSan,omitempty"`
+	KubeletArgs    []string `json:"kubeletArgs,omitempty"`
+	APIServerArgs  []string `json:"apiServerArgs,omitempty"`
+	DataDir        string   `json:"dataDir,omitempty"`
+}
+
 type Wifi struct {
 	Name       string `json:"name,omitempty"`
-	Passphrase string `json:"passphrase,omitempty"`
+	Passphrase string `json:"passphrase,omitempty" secret:"true"`
+}
+
+// Registries is written to the k3s registries.yaml, the mirrors and the configs are keyed by registry host name
+type Registries struct {
+	Mirrors map[string]Mirror         `json:"mirrors,omitempty"`
+	Configs map[string]RegistryConfig `json:"configs,omitempty"`
+}
+
+type Mirror struct {
+	Endpoints []string          `json:"endpoint,omitempty"`
+	Rewrites  map[string]string `json:"rewrite,omitempty"`
+}
+
+type RegistryConfig struct {
+	Auth *RegistryAuth `json:"auth,omitempty"`
+	TLS  *RegistryTLS  `json:"tls,omitempty"`
+}
+
+type RegistryAuth struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty" secret:"true"`
+	Auth          string `json:"auth,omitempty" secret:"true"`
+	IdentityToken string `json:"identityToken,omitempty" secret:"true"`
+}
+
+type RegistryTLS struct {
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// Manifest is deployed by k3s servers, its content is inline or read from File
+type Manifest struct {
+	Name     string `json:"name,omitempty"`
+	Content  string `json:"content,omitempty" secret:"true"`
+	Encoding string `json:"encoding,omitempty"`
+	File     string `json:"file,omitempty"`
+}
+
+// HelmChart is deployed by k3s servers as a HelmChart resource, Values is the YAML of the chart values
+type HelmChart struct {
+	Name            string            `json:"name,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Repo            string            `json:"repo,omitempty"`
+	Chart           string            `json:"chart,omitempty"`
+	Version         string            `json:"version,omitempty"`
+	TargetNamespace string            `json:"targetNamespace,omitempty"`
+	Set             map[string]string `json:"set,omitempty"`
+	Values          string            `json:"values,omitempty"`
+}
+
+// Images are loaded by k3s when it starts, for nodes that can not pull them.  References are the images expected in
+// the containerd store once k3s runs.
+type Images struct {
+	Tarballs   []ImageTarball `json:"tarballs,omitempty"`
+	References []string       `json:"references,omitempty"`
+}
+
+// ImageTarball is a file, a directory of tarballs or a file:// URL on the node, SHA256 is only checked for a file
+type ImageTarball struct {
+	Source string `json:"source,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Network is the static configuration of the network interfaces, the bonds, VLANs and bridges are created before the
+// interfaces are configured
+type Network struct {
+	Interfaces []NetworkInterface `json:"interfaces,omitempty"`
+	VLANs      []VLAN             `json:"vlans,omitempty"`
+	Bonds      []Bond             `json:"bonds,omitempty"`
+	Bridges    []Bridge           `json:"bridges,omitempty"`
+}
+
+// NetworkInterface is matched by Name or MAC, Addresses are IPv4 and IPv6 addresses with their prefix length
+type NetworkInterface struct {
+	Name        string   `json:"name,omitempty"`
+	MAC         string   `json:"mac,omitempty"`
+	Addresses   []string `json:"addresses,omitempty"`
+	Gateway     string   `json:"gateway,omitempty"`
+	Gateway6    string   `json:"gateway6,omitempty"`
+	Nameservers []string `json:"nameservers,omitempty"`
+	Routes      []Route  `json:"routes,omitempty"`
+	MTU         int      `json:"mtu,omitempty"`
+}
+
+type Route struct {
+	To  string `json:"to,omitempty"`
+	Via string `json:"via,omitempty"`
+}
+
+type VLAN struct {
+	Name string `json:"name,omitempty"`
+	Link string `json:"link,omitempty"`
+	ID   int    `json:"id,omitempty"`
+}
+
+// BondModes are the bonding modes in the order of their numbers in the kernel, the first is the default
+var BondModes = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+
+type Bond struct {
+	Name       string   `json:"name,omitempty"`
+	Interfaces []string `json:"interfaces,omitempty"`
+	Mode       string   `json:"mode,omitempty"`
+}
+
+type Bridge struct {
+	Name       string   `json:"name,omitempty"`
+	Interfaces []string `json:"interfaces,omitempty"`
+}
+
+// Proxy is the HTTP proxy of the system, k3s and the downloads of k3os
+type Proxy struct {
+	HTTPProxy  string   `json:"httpProxy,omitempty"`
+	HTTPSProxy string   `json:"httpsProxy,omitempty"`
+	NoProxy    []string `json:"noProxy,omitempty"`
+}
+
+// Swap is set up at boot, the kubelet is told to accept swap when there is any
+type Swap struct {
+	Zram       *Zram  `json:"zram,omitempty"`
+	File       string `json:"file,omitempty"`
+	FileSize   string `json:"fileSize,omitempty"`
+	Swappiness *int   `json:"swappiness,omitempty"`
+}
+
+// Zram is compressed swap in memory, Size is a size such as 512M or a percentage of the memory
+type Zram struct {
+	Size      string `json:"size,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// TimeSync is how the clock is kept in sync.  Makestep is the chrony directive, the offset in seconds above which the
+// clock is stepped rather than slewed and the number of updates after starting it is stepped in, -1 for all of them.
+type TimeSync struct {
+	Servers     []string `json:"servers,omitempty"`
+	Pools       []string `json:"pools,omitempty"`
+	Makestep    string   `json:"makestep,omitempty"`
+	Timezone    string   `json:"timezone,omitempty"`
+	HWClockSync bool     `json:"hwclockSync,omitempty"`
+}
+
+// DefaultMakestep steps the clock in the first updates only, once it is in sync it is only slewed
+const DefaultMakestep = "1.0 3"
+
+// Step returns the threshold and limit of Makestep
+func (t *TimeSync) Step() (time.Duration, int, error) {
+	makestep := t.Makestep
+	if makestep == "" {
+		makestep = DefaultMakestep
+	}
+	return parseMakestep(makestep)
+}
+
+func parseMakestep(val string) (time.Duration, int, error) {
+	fields := strings.Fields(val)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("makestep %q must be a threshold in seconds and a limit such as %q", val, DefaultMakestep)
+	}
+	threshold, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || threshold < 0 {
+		return 0, 0, fmt.Errorf("makestep %q has invalid threshold %q", val, fields[0])
+	}
+	limit, err := strconv.Atoi(fields[1])
+	if err != nil || limit < -1 {
+		return 0, 0, fmt.Errorf("makestep %q has invalid limit %q", val, fields[1])
+	}
+	return time.Duration(threshold * float64(time.Second)), limit, nil
 }
 
 type Install struct {
@@ -40,7 +235,35 @@ type Install struct {
 	TTY       string `json:"tty,omitempty"`
 }
 
+// DefaultUser is the user of k3OS, the top level sshAuthorizedKeys and k3os.password are set for
+const DefaultUser = "rancher"
+
+// User is created or updated in /etc/passwd, /etc/group and /etc/shadow.  The UID is allocated and a group named
+// after the user is its primary group unless they are given.
+type User struct {
+	Name              string   `json:"name,omitempty"`
+	UID               int      `json:"uid,omitempty"`
+	GID               int      `json:"gid,omitempty"`
+	Gecos             string   `json:"gecos,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	Shell             string   `json:"shell,omitempty"`
+	Home              string   `json:"home,omitempty"`
+	LockPassword      bool     `json:"lockPassword,omitempty"`
+	HashedPassword    string   `json:"hashedPassword,omitempty" secret:"true"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+	Sudo              []string `json:"sudo,omitempty"`
+}
+
+// Group is created or updated in /etc/group, Members are added to it
+type Group struct {
+	Name    string   `json:"name,omitempty"`
+	GID     int      `json:"gid,omitempty"`
+	Members []string `json:"members,omitempty"`
+}
+
 type CloudConfig struct {
+	APIVersion        string   `json:"apiVersion,omitempty"`
+	InstanceID        string   `json:"instanceId,omitempty"`
 	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
 	WriteFiles        []File   `json:"writeFiles,omitempty"`
 	Hostname          string   `json:"hostname,omitempty"`
@@ -48,11 +271,36 @@ type CloudConfig struct {
 	Runcmd            []string `json:"runCmd,omitempty"`
 	Bootcmd           []string `json:"bootCmd,omitempty"`
 	Initcmd           []string `json:"initCmd,omitempty"`
+	Users             []User   `json:"users,omitempty"`
+	Groups            []Group  `json:"groups,omitempty"`
+	CACerts           []string `json:"caCerts,omitempty"`
+	Disks             []Disk   `json:"disks,omitempty"`
+	Mounts            []Mount  `json:"mounts,omitempty"`
+}
+
+// Disk is formatted at boot unless it has the filesystem already, Device is a path, a name in /dev/disk/by-id or
+// LABEL= or UUID= a filesystem.  With Partition set the filesystem goes on a single partition spanning the disk.
+type Disk struct {
+	Device      string   `json:"device,omitempty"`
+	Partition   bool     `json:"partition,omitempty"`
+	Filesystem  string   `json:"filesystem,omitempty"`
+	Label       string   `json:"label,omitempty"`
+	MkfsOptions []string `json:"mkfsOptions,omitempty"`
+	Force       bool     `json:"force,omitempty"`
+}
+
+// Mount is mounted at boot, Device is found the same way as that of a Disk, or passed as is for filesystems such as
+// tmpfs
+type Mount struct {
+	Device     string   `json:"device,omitempty"`
+	Path       string   `json:"path,omitempty"`
+	Filesystem string   `json:"filesystem,omitempty"`
+	Options    []string `json:"options,omitempty"`
 }
 
 type File struct {
 	Encoding           string `json:"encoding"`
-	Content            string `json:"content"`
+	Content            string `json:"content" secret:"true"`
 	Owner              string `json:"owner"`
 	Path               string `json:"path"`
 	RawFilePermissions string `json:"permissions"`
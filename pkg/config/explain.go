@@ -0,0 +1,211 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/rancher/mapper"
+	"github.com/rancher/mapper/convert"
+)
+
+// Origin records which source set an effective configuration value
+type Origin struct {
+	Field     string      `json:"field"`
+	Value     interface{} `json:"value"`
+	Source    string      `json:"source"`
+	Overrides []Override  `json:"overrides,omitempty"`
+
+	path []string
+}
+
+// Override is a value from an earlier source that was replaced by a later one
+type Override struct {
+	Source string      `json:"source"`
+	Value  interface{} `json:"value"`
+}
+
+// provenance maps the internal field path of every value set during a merge to its origin
+type provenance map[string]*Origin
+
+// record the origin of the values in newData, fields in combined were merged with the existing value by a merge
+// directive rather than replacing it
+func (p provenance) record(source string, path []string, s *mapper.Schema, data, newData map[string]interface{}, combined map[string]bool) {
+	if p == nil {
+		return
+	}
+	for name, field := range s.ResourceFields {
+		val, ok := newData[name]
+		if !ok {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), name)
+
+		if sub := schemas.Schema(field.Type); sub != nil {
+			if m, ok := val.(map[string]interface{}); ok {
+				existing, _ := data[name].(map[string]interface{})
+				p.record(source, fieldPath, sub, existing, m, combined)
+				continue
+			}
+		}
+
+		key := strings.Join(fieldPath, ".")
+		origin, ok := p[key]
+		if !ok {
+			origin = &Origin{path: fieldPath}
+			p[key] = origin
+		} else if combined[key] {
+			origin.Source = origin.Source + " + " + source
+			origin.Value = val
+			continue
+		} else if !reflect.DeepEqual(origin.Value, val) {
+			origin.Overrides = append(origin.Overrides, Override{
+				Source: origin.Source,
+				Value:  origin.Value,
+			})
+		}
+		origin.Source = source
+		origin.Value = val
+	}
+}
+
+func (p provenance) origins() []Origin {
+	var result []Origin
+	for _, origin := range p {
+		var yamlPath []string
+		for _, name := range origin.path {
+			yamlPath = append(yamlPath, convert.ToYAMLKey(name))
+		}
+		origin.Field = strings.Join(yamlPath, ".")
+		result = append(result, *origin)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Field < result[j].Field
+	})
+	return result
+}
+
+// Explain reads the configuration like ReadConfig and returns the origin of every effective value
+func Explain() ([]Origin, error) {
+	p := provenance{}
+	if _, err := merge(p, sources()...); err != nil {
+		return nil, err
+	}
+	return p.origins(), nil
+}
+
+// RedactOrigins returns the origins with the secret values replaced by Redacted, both the effective ones and the ones
+// they overrode, like Redact does for the configuration
+func RedactOrigins(origins []Origin) []Origin {
+	result := make([]Origin, len(origins))
+	for i, origin := range origins {
+		origin.Value = redactAt(origin.path, origin.Value)
+		overrides := make([]Override, len(origin.Overrides))
+		for j, o := range origin.Overrides {
+			o.Value = redactAt(origin.path, o.Value)
+			overrides[j] = o
+		}
+		origin.Overrides = overrides
+		result[i] = origin
+	}
+	return result
+}
+
+// redactAt returns a copy of the value of the field at the internal path with its secrets redacted
+func redactAt(path []string, val interface{}) interface{} {
+	if len(path) == 0 {
+		return val
+	}
+	data := map[string]interface{}{}
+	parent := data
+	for _, name := range path[:len(path)-1] {
+		m := map[string]interface{}{}
+		parent[name] = m
+		parent = m
+	}
+	name := path[len(path)-1]
+	parent[name] = copyValue(val)
+	Redact(data)
+	return parent[name]
+}
+
+// copyValue copies the maps and lists of a decoded value, so redacting it leaves the original alone
+func copyValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = copyValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = copyValue(item)
+		}
+		return result
+	}
+	return val
+}
+
+// WriteExplain writes the effective configuration as YAML with a comment above each value naming the
+// source it came from and the values it overrode
+func WriteExplain(origins []Origin, writer io.Writer) error {
+	var last []string
+	for _, origin := range origins {
+		path := strings.Split(origin.Field, ".")
+
+		// write the parent keys that differ from the previous value
+		common := 0
+		for common < len(last)-1 && common < len(path)-1 && last[common] == path[common] {
+			common++
+		}
+		for i := common; i < len(path)-1; i++ {
+			if _, err := fmt.Fprintf(writer, "%s%s:\n", indent(i), path[i]); err != nil {
+				return err
+			}
+		}
+		last = path
+
+		prefix := indent(len(path) - 1)
+		if _, err := fmt.Fprintf(writer, "%s# %s\n", prefix, describeOrigin(origin)); err != nil {
+			return err
+		}
+
+		bytes, err := yaml.Marshal(map[string]interface{}{
+			path[len(path)-1]: origin.Value,
+		})
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(bytes), "\n"), "\n") {
+			if _, err := fmt.Fprintf(writer, "%s%s\n", prefix, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func describeOrigin(origin Origin) string {
+	buf := &strings.Builder{}
+	buf.WriteString("from ")
+	buf.WriteString(origin.Source)
+	for i := len(origin.Overrides) - 1; i >= 0; i-- {
+		o := origin.Overrides[i]
+		bytes, err := yaml.Marshal(o.Value)
+		val := strings.TrimSpace(string(bytes))
+		if err != nil || strings.Contains(val, "\n") {
+			val = "a different value"
+		}
+		fmt.Fprintf(buf, ", overrides %s from %s", val, o.Source)
+	}
+	return buf.String()
+}
+
+func indent(depth int) string {
+	return strings.Repeat("  ", depth)
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	p := provenance{}
+	_, err := merge(p,
+		source{"system", func() (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"hostname": "one",
+				"k3os": map[string]interface{}{
+					"server_url": "https://one:6443",
+					"token":      "secret",
+				},
+			}, nil
+		}},
+		source{"local", func() (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"k3os": map[string]interface{}{
+					"serverUrl": "https://two:6443",
+				},
+			}, nil
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteExplain(p.origins(), buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# from system
+hostname: one
+k3os:
+  # from local, overrides https://one:6443 from system
+  server_url: https://two:6443
+  # from system
+  token: secret
+`
+	if buf.String() != expected {
+		t.Fatalf("got:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestRedactOrigins(t *testing.T) {
+	p := provenance{}
+	_, err := merge(p,
+		source{"system", func() (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"k3os": map[string]interface{}{
+					"token": "first",
+				},
+				"write_files": []interface{}{
+					map[string]interface{}{"path": "/etc/one", "content": "one"},
+				},
+			}, nil
+		}},
+		source{"local", func() (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"k3os": map[string]interface{}{
+					"token": "second",
+				},
+			}, nil
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origins := p.origins()
+	buf := &bytes.Buffer{}
+	if err := WriteExplain(RedactOrigins(origins), buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `k3os:
+  # from local, overrides <redacted> from system
+  token: <redacted>
+# from system
+write_files:
+- content: <redacted>
+  path: /etc/one
+`
+	if buf.String() != expected {
+		t.Fatalf("got:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+	// the origins themselves are left alone for --show-secrets
+	if origins[0].Value != "second" || origins[0].Overrides[0].Value != "first" {
+		t.Errorf("origins were changed: %+v", origins[0])
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"encoding/json"
+
+	"github.com/rancher/mapper"
+	"github.com/rancher/mapper/definition"
+)
+
+// JSONSchema returns a JSON Schema (draft-07) for CloudConfig generated from the mapper schemas. Every
+// alternate spelling the reader accepts for a key (snake_case, singular, lower case) is listed as a property.
+func JSONSchema() ([]byte, error) {
+	definitions := map[string]interface{}{}
+	for id, s := range schemas.Schemas() {
+		definitions[id] = objectSchema(s)
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "k3OS configuration",
+		"allOf":       []interface{}{map[string]interface{}{"$ref": "#/definitions/" + schema.ID}},
+		"definitions": definitions,
+	}, "", "  ")
+}
+
+func objectSchema(s *mapper.Schema) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for name, field := range s.ResourceFields {
+		properties[name] = fieldSchema(field.Type)
+	}
+
+	for alias, name := range fuzzyNames(s) {
+		if _, ok := properties[alias]; !ok {
+			properties[alias] = fieldSchema(s.ResourceFields[name].Type)
+		}
+	}
+
+	properties[mergeKey] = map[string]interface{}{
+		"type": "object",
+		"additionalProperties": map[string]interface{}{
+			"type": "string",
+			"enum": mergeDirectives,
+		},
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func fieldSchema(fieldType string) map[string]interface{} {
+	switch {
+	case fieldType == "string":
+		return stringSchema()
+	case fieldType == "boolean":
+		return map[string]interface{}{
+			"anyOf": []interface{}{
+				map[string]interface{}{"type": "boolean"},
+				map[string]interface{}{"type": "string", "enum": []string{"true", "false"}},
+			},
+		}
+	case fieldType == "int":
+		return map[string]interface{}{"type": "integer"}
+	case fieldType == "float":
+		return map[string]interface{}{"type": "number"}
+	case fieldType == "array[string]":
+		// a single string is accepted in place of a list
+		return map[string]interface{}{
+			"anyOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				referenceSchema(),
+				map[string]interface{}{"type": "array", "items": stringSchema()},
+			},
+		}
+	case fieldType == "map[string]":
+		return map[string]interface{}{
+			"type": "object",
+			"additionalProperties": map[string]interface{}{
+				"anyOf": []interface{}{
+					map[string]interface{}{"type": []string{"string", "number", "boolean"}},
+					referenceSchema(),
+				},
+			},
+		}
+	case definition.IsArrayType(fieldType):
+		return map[string]interface{}{
+			"type":  "array",
+			"items": fieldSchema(definition.SubType(fieldType)),
+		}
+	case definition.IsMapType(fieldType):
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": fieldSchema(definition.SubType(fieldType)),
+		}
+	}
+
+	if s := schemas.Schema(fieldType); s != nil {
+		return map[string]interface{}{"$ref": "#/definitions/" + s.ID}
+	}
+	return map[string]interface{}{}
+}
+
+// stringSchema matches a string or a reference to a file or environment variable holding one
+func stringSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"anyOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			referenceSchema(),
+		},
+	}
+}
+
+func referenceSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for name := range referenceKinds {
+		properties[name] = map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+		"minProperties":        1,
+		"maxProperties":        1,
+	}
+}
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/rancher/mapper"
+	"github.com/rancher/mapper/convert"
+	"github.com/rancher/mapper/definition"
+)
+
+const (
+	// MergeReplace replaces the value from earlier sources, this is the default
+	MergeReplace = "replace"
+	// MergeAppend adds the entries after the ones from earlier sources
+	MergeAppend = "append"
+	// MergePrepend adds the entries before the ones from earlier sources
+	MergePrepend = "prepend"
+	// MergeUniqueAppend is like MergeAppend but skips entries that are already present
+	MergeUniqueAppend = "unique-append"
+
+	// mergeKey is the marker key that sets the merge directive of sibling fields inline, for example
+	//   $merge:
+	//     ssh_authorized_keys: append
+	mergeKey = "$merge"
+)
+
+var mergeDirectives = []string{MergeReplace, MergeAppend, MergePrepend, MergeUniqueAppend}
+
+// forEachField calls fn with the schema ID of the struct and the field, for every field of t and the structs it
+// contains
+func forEachField(t reflect.Type, fn func(id string, f reflect.StructField)) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	id := convert.LowerTitle(t.Name())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fn(id, f)
+		if f.Type != t {
+			forEachField(f.Type, fn)
+		}
+	}
+}
+
+func jsonName(f reflect.StructField) string {
+	return strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+}
+
+func isMergeDirective(directive string) bool {
+	for _, d := range mergeDirectives {
+		if d == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMergeDirectives combines the fields of newData that have a merge directive with the existing values in
+// data, so that the following UpdateMerge keeps them.  It returns the paths of the fields that were combined.
+func applyMergeDirectives(path []string, s *mapper.Schema, data, newData map[string]interface{}) (map[string]bool, error) {
+	combined := map[string]bool{}
+
+	directives := map[string]string{}
+	if inline, ok := newData[mergeKey]; ok {
+		delete(newData, mergeKey)
+		m, ok := inline.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a map of field names to merge directives", mergeKey)
+		}
+		names := fuzzyNames(s)
+		for k, v := range m {
+			name := k
+			if _, ok := s.ResourceFields[name]; !ok {
+				name = names[k]
+			}
+			if _, ok := s.ResourceFields[name]; !ok {
+				return nil, fmt.Errorf("%s: unknown field %q", mergeKey, k)
+			}
+			directive := convert.ToString(v)
+			if !isMergeDirective(directive) {
+				return nil, fmt.Errorf("%s: invalid directive %q for %q, must be one of %s", mergeKey, directive, k,
+					strings.Join(mergeDirectives, ", "))
+			}
+			directives[name] = directive
+		}
+	}
+
+	for name, field := range s.ResourceFields {
+		val, ok := newData[name]
+		if !ok {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), name)
+
+		if sub := schemas.Schema(field.Type); sub != nil {
+			m, ok := val.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			existing, _ := data[name].(map[string]interface{})
+			subCombined, err := applyMergeDirectives(fieldPath, sub, existing, m)
+			if err != nil {
+				return nil, err
+			}
+			for k := range subCombined {
+				combined[k] = true
+			}
+			continue
+		}
+
+		directive := directives[name]
+		existing, ok := data[name]
+		if !ok || directive == "" || directive == MergeReplace {
+			continue
+		}
+		if result, ok := mergeValues(directive, existing, val); ok {
+			newData[name] = result
+			combined[strings.Join(fieldPath, ".")] = true
+		}
+	}
+
+	return combined, nil
+}
+
+// mergeValues combines lists or maps according to the directive, other values can not be combined
+func mergeValues(directive string, existing, val interface{}) (interface{}, bool) {
+	if existingMap, ok := toMap(existing); ok {
+		valMap, ok := toMap(val)
+		if !ok {
+			return nil, false
+		}
+		first, second := existingMap, valMap
+		if directive == MergePrepend {
+			// entries from earlier sources win
+			first, second = valMap, existingMap
+		}
+		result := map[string]interface{}{}
+		for k, v := range first {
+			result[k] = v
+		}
+		for k, v := range second {
+			result[k] = v
+		}
+		return result, true
+	}
+
+	existingList, ok := toSlice(existing)
+	if !ok {
+		return nil, false
+	}
+	valList, ok := toSlice(val)
+	if !ok {
+		return nil, false
+	}
+
+	switch directive {
+	case MergePrepend:
+		return append(append([]interface{}{}, valList...), existingList...), true
+	case MergeUniqueAppend:
+		result := append([]interface{}{}, existingList...)
+		for _, v := range valList {
+			if !containsValue(result, v) {
+				result = append(result, v)
+			}
+		}
+		return result, true
+	}
+	return append(append([]interface{}{}, existingList...), valList...), true
+}
+
+// dropAliases removes the alternate spellings of field names that FuzzyNames leaves behind after copying their
+// values to the real field names, so that only the real names are merged
+func dropAliases(s *mapper.Schema, data map[string]interface{}) {
+	if data == nil {
+		return
+	}
+	for alias := range fuzzyNames(s) {
+		if _, ok := s.ResourceFields[alias]; !ok {
+			delete(data, alias)
+		}
+	}
+	for name, field := range s.ResourceFields {
+		fieldType := field.Type
+		isMap := definition.IsMapType(fieldType)
+		if isMap || definition.IsArrayType(fieldType) {
+			fieldType = definition.SubType(fieldType)
+		}
+		sub := schemas.Schema(fieldType)
+		if sub == nil {
+			continue
+		}
+		switch v := data[name].(type) {
+		case map[string]interface{}:
+			if !isMap {
+				dropAliases(sub, v)
+				continue
+			}
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					dropAliases(sub, m)
+				}
+			}
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					dropAliases(sub, m)
+				}
+			}
+		}
+	}
+}
+
+func containsValue(list []interface{}, val interface{}) bool {
+	for _, v := range list {
+		if reflect.DeepEqual(v, val) {
+			return true
+		}
+	}
+	return false
+}
+
+func toMap(val interface{}) (map[string]interface{}, bool) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[string]string:
+		result := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			result[k] = s
+		}
+		return result, true
+	}
+	return nil, false
+}
@@ -0,0 +1,324 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/rancher/k3os/pkg/system"
+	"github.com/rancher/k3os/pkg/util"
+	"github.com/rancher/mapper"
+	"github.com/rancher/mapper/convert"
+)
+
+const (
+	// APIVersionV1 is the first versioned configuration format, configuration without an apiVersion is older
+	APIVersionV1 = "k3os.io/v1"
+	// APIVersion is the current configuration format, older configuration is migrated to it when read
+	APIVersion = APIVersionV1
+)
+
+// MigrateBackups is where `config migrate` saves the original files
+var MigrateBackups = system.LocalPath("backup")
+
+// migration upgrades the raw configuration from one apiVersion to the next
+type migration struct {
+	from, to string
+	migrate  func(data map[string]interface{}) error
+}
+
+var migrations = []migration{
+	{"", APIVersionV1, migrateLegacy},
+}
+
+// migrate upgrades the raw configuration in data to the current APIVersion, it returns true if data was changed
+func migrate(data map[string]interface{}) (bool, error) {
+	if data == nil {
+		return false, nil
+	}
+
+	key, _ := fieldKey(schema, data, "apiVersion")
+	version := ""
+	if key != "" {
+		version = convert.ToString(data[key])
+	} else {
+		key = "apiVersion"
+	}
+	if version == APIVersion {
+		return false, nil
+	}
+
+	for _, m := range migrations {
+		if m.from != version {
+			continue
+		}
+		if err := m.migrate(data); err != nil {
+			return false, fmt.Errorf("failed to migrate from apiVersion %q to %q: %v", m.from, m.to, err)
+		}
+		version = m.to
+	}
+	if version != APIVersion {
+		return false, fmt.Errorf("unsupported apiVersion %q, expected %q", version, APIVersion)
+	}
+
+	data[key] = version
+	return true, nil
+}
+
+// migrateLegacy converts the shapes accepted by older releases: octal numbers for file permissions, lists of
+// key=value strings for labels and environment, and k3os.token and k3os.server_url are moved to k3os.k3s
+func migrateLegacy(data map[string]interface{}) error {
+	if files, ok := fieldValue(schema, data, "writeFiles").([]interface{}); ok {
+		fileSchema := schemas.Schema("file")
+		for _, f := range files {
+			file, ok := f.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key, ok := fieldKey(fileSchema, file, "permissions")
+			if !ok {
+				continue
+			}
+			if perm, ok := file[key].(float64); ok {
+				file[key] = fmt.Sprintf("%04o", int(perm))
+			}
+		}
+	}
+
+	k3os, ok := fieldValue(schema, data, "k3os").(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	k3osSchema := schemas.Schema("k3OS")
+	for _, name := range []string{"labels", "environment"} {
+		key, ok := fieldKey(k3osSchema, k3os, name)
+		if !ok {
+			continue
+		}
+		list, ok := k3os[key].([]interface{})
+		if !ok {
+			continue
+		}
+		m := map[string]interface{}{}
+		for _, item := range list {
+			parts := strings.SplitN(convert.ToString(item), "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("%s: %q is not in the form key=value", convert.ToYAMLKey(name), item)
+			}
+			m[parts[0]] = parts[1]
+		}
+		k3os[key] = m
+	}
+	return moveToK3S(k3osSchema, k3os)
+}
+
+// k3sAliases are the fields of k3os that can be written in the k3os.k3s section too, with the other k3s settings.
+// `config migrate` moves them there, and foldK3SAliases moves them back when the configuration is read, as the rest
+// of k3os reads them from k3os.
+var k3sAliases = []string{"serverUrl", "token"}
+
+// moveToK3S moves the k3sAliases of k3os to the k3os.k3s section, keeping the way their keys are written
+func moveToK3S(k3osSchema *mapper.Schema, k3os map[string]interface{}) error {
+	for _, name := range k3sAliases {
+		key, ok := fieldKey(k3osSchema, k3os, name)
+		if !ok {
+			continue
+		}
+		k3s, err := k3sSection(k3osSchema, k3os, true)
+		if err != nil {
+			return err
+		}
+		if alias := k3sAlias(k3s, name); alias != "" {
+			return fmt.Errorf("k3os.%s: k3os.k3s.%s is set too", key, alias)
+		}
+		k3s[key] = k3os[key]
+		delete(k3os, key)
+	}
+	return nil
+}
+
+// foldK3SAliases moves the k3sAliases of the k3os.k3s section back to k3os, the section is removed if nothing else is
+// left in it
+func foldK3SAliases(data map[string]interface{}) error {
+	k3os, ok := fieldValue(schema, data, "k3os").(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	k3osSchema := schemas.Schema("k3OS")
+	k3s, err := k3sSection(k3osSchema, k3os, false)
+	if err != nil || k3s == nil {
+		return err
+	}
+	for _, name := range k3sAliases {
+		alias := k3sAlias(k3s, name)
+		if alias == "" {
+			continue
+		}
+		if key, ok := fieldKey(k3osSchema, k3os, name); ok {
+			return fmt.Errorf("k3os.k3s.%s: k3os.%s is set too", alias, key)
+		}
+		k3os[alias] = k3s[alias]
+		delete(k3s, alias)
+	}
+	if len(k3s) == 0 {
+		key, _ := fieldKey(k3osSchema, k3os, "k3s")
+		delete(k3os, key)
+	}
+	return nil
+}
+
+// k3sSection returns the k3os.k3s section, it is added if create is set
+func k3sSection(k3osSchema *mapper.Schema, k3os map[string]interface{}, create bool) (map[string]interface{}, error) {
+	key, ok := fieldKey(k3osSchema, k3os, "k3s")
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		key = "k3s"
+		k3os[key] = map[string]interface{}{}
+	}
+	k3s, ok := k3os[key].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("k3os.k3s: expected a map")
+	}
+	return k3s, nil
+}
+
+// k3sAlias returns the key of the alias in the k3os.k3s section, which is not part of its schema so only the camel
+// and snake case keys are recognized
+func k3sAlias(k3s map[string]interface{}, name string) string {
+	for _, key := range []string{name, convert.ToYAMLKey(name)} {
+		if _, ok := k3s[key]; ok {
+			return key
+		}
+	}
+	return ""
+}
+
+// fieldKey returns the key used in data for a field of schema s, which may be any of its alternate names
+func fieldKey(s *mapper.Schema, data map[string]interface{}, name string) (string, bool) {
+	if _, ok := data[name]; ok {
+		return name, true
+	}
+	names := fuzzyNames(s)
+	for k := range data {
+		if names[k] == name {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func fieldValue(s *mapper.Schema, data map[string]interface{}, name string) interface{} {
+	if key, ok := fieldKey(s, data, name); ok {
+		return data[key]
+	}
+	return nil
+}
+
+// MigrateResult describes a configuration file rewritten by Migrate
+type MigrateResult struct {
+	Path   string `json:"path"`
+	Backup string `json:"backup,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Migrate rewrites the local configuration files that are older than APIVersion, the original files are copied to
+// MigrateBackups first.  Templates are skipped as they would be rewritten with the facts of this node.
+func Migrate() []MigrateResult {
+	var result []MigrateResult
+
+	paths := []string{LocalConfig}
+	for _, s := range readLocalConfigs() {
+		paths = append(paths, s.name)
+	}
+
+	now := time.Now().UTC().Format("20060102T150405")
+	for _, p := range paths {
+		backup, err := migrateFile(p, now)
+		if err != nil {
+			result = append(result, MigrateResult{Path: p, Error: err.Error()})
+		} else if backup != "" {
+			result = append(result, MigrateResult{Path: p, Backup: backup})
+		}
+	}
+	return result
+}
+
+func migrateFile(path, suffix string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if isTemplate(content) {
+		return "", fmt.Errorf("templates can not be migrated automatically")
+	}
+
+	data := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return "", err
+	}
+	original := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &original); err != nil {
+		return "", err
+	}
+	if changed, err := migrate(data); err != nil || !changed {
+		return "", err
+	}
+
+	bytes, err := migrated(content, original, data)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(MigrateBackups, 0700); err != nil {
+		return "", err
+	}
+	rel := strings.TrimPrefix(path, system.LocalPath()+"/")
+	backup := filepath.Join(MigrateBackups, strings.Replace(rel, "/", "_", -1)+"."+suffix)
+	if err := ioutil.WriteFile(backup, content, 0600); err != nil {
+		return "", err
+	}
+
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return backup, util.WriteFileAtomic(path, bytes, perm)
+}
+
+// migrated returns the content of the migrated file.  If only the apiVersion was added the line is inserted, so the
+// comments and the order of the keys are kept, otherwise the whole document is written again.
+func migrated(content []byte, original, data map[string]interface{}) ([]byte, error) {
+	key, _ := fieldKey(schema, data, "apiVersion")
+	if _, ok := original[key]; ok {
+		return yaml.Marshal(data)
+	}
+	withoutVersion := map[string]interface{}{}
+	for k, v := range data {
+		if k != key {
+			withoutVersion[k] = v
+		}
+	}
+	if !reflect.DeepEqual(original, withoutVersion) {
+		return yaml.Marshal(data)
+	}
+
+	line := fmt.Sprintf("%s: %s\n", key, data[key])
+	// keep the header of cloud-config files and the document start marker first
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		first := strings.TrimSpace(string(content[:i]))
+		if first == "---" || strings.HasPrefix(first, "#cloud-config") {
+			return append(append(append([]byte{}, content[:i+1]...), line...), content[i+1:]...), nil
+		}
+	}
+	return append([]byte(line), content...), nil
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const legacyConfig = `write_files:
+- path: /etc/motd
+  permissions: 0600
+k3os:
+  server_url: https://10.0.0.1:6443
+  token: K10secret
+  labels:
+  - region=us-east
+  environment:
+  - HTTP_PROXY=http://proxy:3128
+`
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(backups string) { MigrateBackups = backups }(MigrateBackups)
+	MigrateBackups = filepath.Join(dir, "backup")
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(legacyConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := readersToObject(func() (map[string]interface{}, error) {
+		return readFile(path)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.WriteFiles[0].RawFilePermissions != "0600" {
+		t.Errorf("permissions %q != 0600", cc.WriteFiles[0].RawFilePermissions)
+	}
+	if cc.K3OS.Labels["region"] != "us-east" || cc.K3OS.Environment["HTTP_PROXY"] != "http://proxy:3128" {
+		t.Errorf("lists were not converted to maps: %+v", cc.K3OS)
+	}
+	if cc.K3OS.ServerURL != "https://10.0.0.1:6443" || cc.K3OS.Token != "K10secret" || cc.K3OS.K3S != nil {
+		t.Errorf("server_url and token were not read: %+v", cc.K3OS)
+	}
+
+	backup, err := migrateFile(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(backup); err != nil || string(content) != legacyConfig {
+		t.Errorf("backup %s does not have the original content: %v", backup, err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "apiVersion: "+APIVersion) || !strings.Contains(string(content), "region: us-east") ||
+		!strings.Contains(string(content), "  k3s:\n    server_url: https://10.0.0.1:6443\n    token: K10secret\n") {
+		t.Errorf("file was not migrated:\n%s", content)
+	}
+
+	// the file is current now
+	if backup, err := migrateFile(path, "again"); err != nil || backup != "" {
+		t.Errorf("current file was migrated again: %s %v", backup, err)
+	}
+
+	if _, err := migrate(map[string]interface{}{"api_version": "k3os.io/v0"}); err == nil {
+		t.Error("expected an error for an unsupported apiVersion")
+	}
+	legacy := map[string]interface{}{"k3os": map[string]interface{}{
+		"token": "one",
+		"k3s":   map[string]interface{}{"token": "two"},
+	}}
+	if _, err := migrate(legacy); err == nil {
+		t.Error("expected an error for a token in k3os and k3os.k3s")
+	}
+}
+
+func TestK3SAliases(t *testing.T) {
+	cc, err := ToConfig(map[string]interface{}{
+		"apiVersion": APIVersion,
+		"k3os": map[string]interface{}{
+			"k3s": map[string]interface{}{
+				"server_url": "https://10.0.0.1:6443",
+				"token":      "K10secret",
+				"node_ip":    "10.0.0.2",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.K3OS.ServerURL != "https://10.0.0.1:6443" || cc.K3OS.Token != "K10secret" || cc.K3OS.K3S.NodeIP != "10.0.0.2" {
+		t.Errorf("k3os.k3s aliases were not read: %+v %+v", cc.K3OS, cc.K3OS.K3S)
+	}
+
+	// without other k3s settings there is no k3os.k3s section, so config.yaml is left alone
+	cc, err = ToConfig(map[string]interface{}{
+		"apiVersion": APIVersion,
+		"k3os":       map[string]interface{}{"k3s": map[string]interface{}{"token": "K10secret"}},
+	})
+	if err != nil || cc.K3OS.Token != "K10secret" || cc.K3OS.K3S != nil {
+		t.Errorf("unexpected k3os %+v: %v", cc.K3OS, err)
+	}
+
+	if _, err := ToConfig(map[string]interface{}{
+		"apiVersion": APIVersion,
+		"k3os": map[string]interface{}{
+			"token": "one",
+			"k3s":   map[string]interface{}{"token": "two"},
+		},
+	}); err == nil {
+		t.Error("expected an error for a token in k3os and k3os.k3s")
+	}
+}
+
+func TestMigrateVersionOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(backups string) { MigrateBackups = backups }(MigrateBackups)
+	MigrateBackups = filepath.Join(dir, "backup")
+
+	// nothing but the apiVersion changes, so the comments and the order of the keys are kept
+	current := "#cloud-config\n# the name of this node\nhostname: one\nk3os:\n  k3s:\n    token: K10secret\n"
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(current), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrateFile(path, "test"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "#cloud-config\napiVersion: " + APIVersion + "\n# the name of this node\nhostname: one\nk3os:\n  k3s:\n" +
+		"    token: K10secret\n"
+	if content, err := ioutil.ReadFile(path); err != nil || string(content) != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", content, expected)
+	}
+}
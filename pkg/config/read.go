@@ -24,6 +24,8 @@ var (
 	localConfigs = system.LocalPath("config.d")
 )
 
+const cmdline = "/proc/cmdline"
+
 var (
 	schemas = mapper.NewSchemas().Init(func(s *mapper.Schemas) *mapper.Schemas {
 		s.DefaultMappers = func() []mapper.Mapper {
@@ -37,20 +39,22 @@ var (
 		return s
 	}).MustImport(CloudConfig{})
 	schema  = schemas.Schema("cloudConfig")
-	readers = []reader{
-		readSystemConfig,
-		readCmdline,
-		readLocalConfig,
-		readCloudConfig,
-		readUserData,
+	readers = []source{
+		{SystemConfig, readSystemConfig},
+		{cmdline, readCmdline},
+		{LocalConfig, readLocalConfig},
+		{"/run/config", readCloudConfig},
+		{userdata, readUserData},
 	}
 )
 
+// ToEnv returns the configuration as environment variables, without the secret fields
 func ToEnv(cfg CloudConfig) ([]string, error) {
 	data, err := convert.EncodeToMap(&cfg)
 	if err != nil {
 		return nil, err
 	}
+	stripSecrets(data)
 
 	return mapToEnv("", data), nil
 }
@@ -70,36 +74,98 @@ func mapToEnv(prefix string, data map[string]interface{}) []string {
 }
 
 func ReadConfig() (CloudConfig, error) {
-	return readersToObject(append(readers, readLocalConfigs()...)...)
+	return sourcesToObject(sources()...)
+}
+
+// ToConfig merges raw configuration maps in order, the same way the configuration files are merged
+func ToConfig(datas ...map[string]interface{}) (CloudConfig, error) {
+	var readers []reader
+	for _, data := range datas {
+		data := data
+		readers = append(readers, func() (map[string]interface{}, error) {
+			return data, nil
+		})
+	}
+	return readersToObject(readers...)
 }
 
 func readersToObject(readers ...reader) (CloudConfig, error) {
+	var srcs []source
+	for i, r := range readers {
+		srcs = append(srcs, source{fmt.Sprintf("reader[%d]", i), r})
+	}
+	return sourcesToObject(srcs...)
+}
+
+func sourcesToObject(srcs ...source) (CloudConfig, error) {
+	result, _, err := resolveSources(srcs...)
+	return result, err
+}
+
+// resolveSources merges the sources and resolves the references to files and environment variables, the returned
+// resolver can put the references back
+func resolveSources(srcs ...source) (CloudConfig, *resolver, error) {
 	result := CloudConfig{
 		K3OS: K3OS{
 			Install: &Install{},
 		},
 	}
 
-	data, err := merge(readers...)
+	data, err := merge(nil, srcs...)
 	if err != nil {
-		return result, err
+		return result, nil, err
+	}
+
+	r := &resolver{}
+	if _, err := r.resolve(nil, data); err != nil {
+		return result, nil, err
+	}
+
+	if _, err := (&unsealer{}).unseal("", data); err != nil {
+		return result, nil, err
 	}
 
-	return result, convert.ToObj(data, &result)
+	return result, r, convert.ToObj(data, &result)
 }
 
 type reader func() (map[string]interface{}, error)
 
-func merge(readers ...reader) (map[string]interface{}, error) {
+// source is a reader along with the name (usually a path) it reads from
+type source struct {
+	name string
+	read reader
+}
+
+// sources returns every configuration source in the order they are merged
+func sources() []source {
+	return append(readers, readLocalConfigs()...)
+}
+
+// merge reads and merges the sources in order, if p is not nil it records where each value came from
+func merge(p provenance, srcs ...source) (map[string]interface{}, error) {
 	data := map[string]interface{}{}
-	for _, r := range readers {
-		newData, err := r()
+	for _, s := range srcs {
+		newData, err := s.read()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to read %s: %v", s.name, err)
+		}
+		if _, err := migrate(newData); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", s.name, err)
+		}
+		if err := foldK3SAliases(newData); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", s.name, err)
 		}
 		if err := schema.Mapper.ToInternal(newData); err != nil {
 			return nil, err
 		}
+		dropAliases(schema, newData)
+		// the version describes the source, the merged configuration is always the current version
+		delete(newData, "apiVersion")
+		combined, err := applyMergeDirectives(nil, schema, data, newData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge %s: %v", s.name, err)
+		}
+		p.record(s.name, nil, schema, data, newData, combined)
 		data = merge2.UpdateMerge(schema, schemas, data, newData, false)
 	}
 	return data, nil
@@ -113,25 +179,25 @@ func readLocalConfig() (map[string]interface{}, error) {
 	return readFile(LocalConfig)
 }
 
-func readLocalConfigs() []reader {
-	var result []reader
+func readLocalConfigs() []source {
+	var result []source
 
 	files, err := ioutil.ReadDir(localConfigs)
 	if os.IsNotExist(err) {
 		return nil
 	} else if err != nil {
-		return []reader{
-			func() (map[string]interface{}, error) {
+		return []source{
+			{localConfigs, func() (map[string]interface{}, error) {
 				return nil, err
-			},
+			}},
 		}
 	}
 
 	for _, f := range files {
 		p := filepath.Join(localConfigs, f.Name())
-		result = append(result, func() (map[string]interface{}, error) {
+		result = append(result, source{p, func() (map[string]interface{}, error) {
 			return readFile(p)
-		})
+		}})
 	}
 
 	return result
@@ -145,6 +211,11 @@ func readFile(path string) (map[string]interface{}, error) {
 		return nil, err
 	}
 
+	f, err = renderTemplate(path, f)
+	if err != nil {
+		return nil, err
+	}
+
 	data := map[string]interface{}{}
 	if err := yaml.Unmarshal(f, &data); err != nil {
 		return nil, err
@@ -160,7 +231,7 @@ func readCmdline() (map[string]interface{}, error) {
 		return nil, nil
 	}
 
-	bytes, err := ioutil.ReadFile("/proc/cmdline")
+	bytes, err := ioutil.ReadFile(cmdline)
 	if os.IsNotExist(err) {
 		return nil, nil
 	} else if err != nil {
@@ -3,18 +3,28 @@ package config
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
 	"os"
 	"strings"
 
 	"github.com/ghodss/yaml"
+	"github.com/rancher/k3os/pkg/util"
 	"github.com/rancher/mapper/convert"
+	"github.com/sirupsen/logrus"
 )
 
 const (
-	hostname = "/run/config/local_hostname"
-	ssh      = "/run/config/ssh/authorized_keys"
-	userdata = "/run/config/userdata"
+	hostname    = "/run/config/local_hostname"
+	ssh         = "/run/config/ssh/authorized_keys"
+	userdata    = "/run/config/userdata"
+	networkJSON = "/run/config/network.json"
 )
 
 func readCloudConfig() (map[string]interface{}, error) {
@@ -26,6 +36,19 @@ func readCloudConfig() (map[string]interface{}, error) {
 		result["hostname"] = strings.TrimSpace(string(hostname))
 	}
 
+	if id := readFact(instanceID); id != "" {
+		result["instance_id"] = id
+	}
+
+	// the k3os.network and k3os.dns_nameservers of the data source
+	if data, err := ioutil.ReadFile(networkJSON); err == nil {
+		k3os := map[string]interface{}{}
+		if err := json.Unmarshal(data, &k3os); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", networkJSON, err)
+		}
+		result["k3os"] = k3os
+	}
+
 	keyData, err := ioutil.ReadFile(ssh)
 	if err != nil {
 		// ignore error
@@ -47,8 +70,6 @@ func readCloudConfig() (map[string]interface{}, error) {
 }
 
 func readUserData() (map[string]interface{}, error) {
-	result := map[string]interface{}{}
-
 	data, err := ioutil.ReadFile(userdata)
 	if os.IsNotExist(err) {
 		return nil, nil
@@ -56,32 +77,274 @@ func readUserData() (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	cc := CloudConfig{}
-	script := false
-	if bytes.Contains(data, []byte{0}) {
-		script = true
-		cc.WriteFiles = []File{
-			{
-				Content:  base64.StdEncoding.EncodeToString(data),
-				Encoding: "b64",
-			},
+	return ParseUserData(data)
+}
+
+// ParseUserData converts cloud-init user-data to configuration. The user-data can be a YAML cloud-config, a script,
+// gzip compressed, a multi-part MIME archive or an #include list of URLs. Cloud-config parts are merged in order and
+// every script is written to its own file under /run/k3os and sourced by run_cmd.  The URLs of #include are not
+// fetched, see ResolveIncludes.
+func ParseUserData(data []byte) (map[string]interface{}, error) {
+	u := &userData{}
+	if err := u.parse(data, 0); err != nil {
+		return nil, err
+	}
+	return u.toMap()
+}
+
+// ResolveIncludes fetches the URLs of #include and text/x-include-url user-data.  Without any it returns the
+// user-data unchanged, otherwise a multi-part MIME archive of the cloud-configs and scripts found, so reading the
+// configuration never fetches them again.
+func ResolveIncludes(data []byte) ([]byte, error) {
+	u := &userData{include: util.HTTPLoadBytes}
+	if err := u.parse(data, 0); err != nil {
+		return nil, err
+	}
+	if !u.included {
+		return data, nil
+	}
+	return u.archive()
+}
+
+// maxIncludeDepth limits how deep #include and nested archives are followed
+const maxIncludeDepth = 5
+
+type userData struct {
+	// configs are rendered as templates when they are converted, so they can be archived as they are
+	configs  [][]byte
+	scripts  [][]byte
+	include  func(url string) ([]byte, error)
+	included bool
+}
+
+func (u *userData) parse(data []byte, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("user-data nested more than %d levels deep", maxIncludeDepth)
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		content, err := util.DecompressGzip(data)
+		if err != nil {
+			return err
+		}
+		return u.parse(content, depth+1)
+	case bytes.HasPrefix(data, []byte("#include")):
+		return u.parseInclude(data, depth)
+	case bytes.HasPrefix(data, []byte("#!")), bytes.Contains(data, []byte{0}):
+		u.scripts = append(u.scripts, data)
+		return nil
+	case isMIME(data):
+		return u.parseMIME(data, depth)
+	}
+
+	u.configs = append(u.configs, data)
+	return nil
+}
+
+func (u *userData) parseInclude(data []byte, depth int) error {
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if u.include == nil {
+			logrus.Warnf("ignoring #include %s, it was not fetched by the data source", line)
+			continue
+		}
+		content, err := u.include(line)
+		if err != nil {
+			return fmt.Errorf("failed to include %s: %v", line, err)
+		}
+		if err := u.parse(content, depth+1); err != nil {
+			return fmt.Errorf("failed to include %s: %v", line, err)
+		}
+		u.included = true
+	}
+	return nil
+}
+
+func isMIME(data []byte) bool {
+	header := strings.ToLower(string(data[:minInt(len(data), 512)]))
+	return strings.HasPrefix(header, "content-type: multipart/") ||
+		strings.HasPrefix(header, "mime-version:") ||
+		strings.Contains(header, "\ncontent-type: multipart/")
+}
+
+func (u *userData) parseMIME(data []byte, depth int) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return u.parseMultipart(msg.Header.Get("Content-Type"), msg.Body, depth)
+}
+
+func (u *userData) parseMultipart(contentType string, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Errorf("unsupported user-data content type %s", mediaType)
+	}
+
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := u.parsePart(part, depth); err != nil {
+			return err
+		}
+	}
+}
+
+func (u *userData) parsePart(part *multipart.Part, depth int) error {
+	var body io.Reader = part
+	if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+		body = base64.NewDecoder(base64.StdEncoding, part)
+	}
+
+	contentType := part.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return u.parseMultipart(contentType, body, depth+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case "text/cloud-config":
+		u.configs = append(u.configs, data)
+	case "text/x-shellscript":
+		u.scripts = append(u.scripts, data)
+	case "text/x-include-url":
+		return u.parseInclude(append([]byte("#include\n"), data...), depth+1)
+	case "text/plain", "application/octet-stream", "application/x-gzip", "application/gzip":
+		return u.parse(data, depth+1)
+	default:
+		logrus.Warnf("ignoring unsupported user-data part %s", mediaType)
+	}
+	return nil
+}
+
+// archive returns the cloud-configs and scripts as a multi-part MIME archive, base64 encoded as scripts may be binary
+func (u *userData) archive() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n", w.Boundary())
+	for _, part := range []struct {
+		contentType string
+		contents    [][]byte
+	}{
+		{"text/cloud-config", u.configs},
+		{"text/x-shellscript", u.scripts},
+	} {
+		for _, content := range part.contents {
+			pw, err := w.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"base64"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			enc := base64.NewEncoder(base64.StdEncoding, pw)
+			if _, err := enc.Write(content); err != nil {
+				return nil, err
+			}
+			if err := enc.Close(); err != nil {
+				return nil, err
+			}
 		}
-	} else if strings.HasPrefix(string(data), "#!") {
-		script = true
-		cc.WriteFiles = []File{
-			{
-				Content: string(data),
-			},
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseConfig renders a cloud-config as a template and parses it
+func parseConfig(data []byte) (map[string]interface{}, error) {
+	data, err := renderTemplate("user-data", data)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (u *userData) toMap() (map[string]interface{}, error) {
+	var configs []map[string]interface{}
+	for _, data := range u.configs {
+		c, err := parseConfig(data)
+		if err != nil {
+			return nil, err
 		}
+		configs = append(configs, c)
+	}
+	if len(configs) == 1 && len(u.scripts) == 0 {
+		return configs[0], nil
+	}
+
+	var srcs []source
+	for i, c := range configs {
+		c := c
+		srcs = append(srcs, source{fmt.Sprintf("%s part %d", userdata, i), func() (map[string]interface{}, error) {
+			return c, nil
+		}})
+	}
+	result, err := merge(nil, srcs...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(u.scripts) == 0 {
+		return result, nil
 	}
 
-	if script {
-		cc.WriteFiles[0].Owner = "root"
-		cc.WriteFiles[0].RawFilePermissions = "0700"
-		cc.WriteFiles[0].Path = "/run/k3os/userdata"
-		cc.Runcmd = []string{"source /run/k3os/userdata"}
+	cc := CloudConfig{}
+	for i, script := range u.scripts {
+		path := "/run/k3os/userdata"
+		if len(u.scripts) > 1 {
+			path = fmt.Sprintf("/run/k3os/userdata-%d", i)
+		}
+		f := File{
+			Content:            string(script),
+			Owner:              "root",
+			Path:               path,
+			RawFilePermissions: "0700",
+		}
+		if bytes.Contains(script, []byte{0}) {
+			f.Content = base64.StdEncoding.EncodeToString(script)
+			f.Encoding = "b64"
+		}
+		cc.WriteFiles = append(cc.WriteFiles, f)
+		cc.Runcmd = append(cc.Runcmd, "source "+path)
+	}
 
-		return convert.EncodeToMap(cc)
+	scripts, err := convert.EncodeToMap(cc)
+	if err != nil {
+		return nil, err
 	}
-	return result, yaml.Unmarshal(data, &result)
+	for _, key := range []string{"writeFiles", "runCmd"} {
+		if existing, ok := result[key]; ok {
+			result[key], _ = mergeValues(MergeAppend, existing, scripts[key])
+		} else {
+			result[key] = scripts[key]
+		}
+	}
+	return result, nil
 }
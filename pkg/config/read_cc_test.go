@@ -0,0 +1,142 @@
+package config
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const multipartUserData = `Content-Type: multipart/mixed; boundary="==BOUNDARY=="
+MIME-Version: 1.0
+
+--==BOUNDARY==
+Content-Type: text/cloud-config; charset="us-ascii"
+
+hostname: one
+run_cmd:
+- echo config
+k3os:
+  token: secret
+
+--==BOUNDARY==
+Content-Type: text/x-shellscript; charset="us-ascii"
+Content-Transfer-Encoding: base64
+
+IyEvYmluL3NoCmVjaG8gb25lCg==
+--==BOUNDARY==
+Content-Type: text/cloud-config; charset="us-ascii"
+
+hostname: two
+
+--==BOUNDARY==
+Content-Type: text/x-shellscript; charset="us-ascii"
+
+#!/bin/sh
+echo two
+
+--==BOUNDARY==--
+`
+
+func TestParseUserDataMultipart(t *testing.T) {
+	data, err := ParseUserData([]byte(multipartUserData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := CloudConfig{}
+	if err := sourcesToObjectInto(data, &cc); err != nil {
+		t.Fatal(err)
+	}
+
+	if cc.Hostname != "two" || cc.K3OS.Token != "secret" {
+		t.Errorf("cloud-config parts were not merged: %+v", cc)
+	}
+	if !reflect.DeepEqual(cc.Runcmd, []string{"echo config", "source /run/k3os/userdata-0", "source /run/k3os/userdata-1"}) {
+		t.Errorf("unexpected run_cmd %v", cc.Runcmd)
+	}
+	if len(cc.WriteFiles) != 2 || cc.WriteFiles[0].Content != "#!/bin/sh\necho one\n" || cc.WriteFiles[1].Path != "/run/k3os/userdata-1" {
+		t.Errorf("unexpected write_files %+v", cc.WriteFiles)
+	}
+}
+
+func TestParseUserDataGzipInclude(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "#cloud-config\nhostname: %s\n", r.URL.Path[1:])
+	}))
+	defer server.Close()
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	fmt.Fprintf(gz, "#include\n%s/one\n# comment\n%s/two\n", server.URL, server.URL)
+	gz.Close()
+
+	resolved, err := ResolveIncludes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the resolved user-data is read without fetching the includes again
+	server.Close()
+
+	data, err := ParseUserData(resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := CloudConfig{}
+	if err := sourcesToObjectInto(data, &cc); err != nil {
+		t.Fatal(err)
+	}
+	if cc.Hostname != "two" {
+		t.Errorf("got hostname %q, expected two", cc.Hostname)
+	}
+	if len(cc.WriteFiles) != 0 {
+		t.Errorf("gzip user-data was treated as a script")
+	}
+
+	if data, err = ParseUserData(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("unresolved includes were read: %v", data)
+	}
+
+	if resolved, err = ResolveIncludes([]byte(multipartUserData)); err != nil {
+		t.Fatal(err)
+	}
+	if string(resolved) != multipartUserData {
+		t.Error("user-data without includes was changed")
+	}
+}
+
+func TestParseUserDataTemplatePart(t *testing.T) {
+	factsOnce.Do(func() {
+		facts = Facts{
+			MAC: "52:54:00:AB:CD:EF",
+			DMI: DMI{Serial: "SN1234"},
+		}
+	})
+
+	data, err := ParseUserData([]byte(strings.Replace(multipartUserData, "hostname: two",
+		"## template: go\nhostname: edge-{{ .MAC | short }}", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := CloudConfig{}
+	if err := sourcesToObjectInto(data, &cc); err != nil {
+		t.Fatal(err)
+	}
+	if cc.Hostname != "edge-abcdef" {
+		t.Errorf("cloud-config part was not rendered, got hostname %q", cc.Hostname)
+	}
+}
+
+func sourcesToObjectInto(data map[string]interface{}, cc *CloudConfig) error {
+	var err error
+	*cc, err = readersToObject(func() (map[string]interface{}, error) {
+		return data, nil
+	})
+	return err
+}
@@ -1,6 +1,9 @@
 package config
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestDataSource(t *testing.T) {
 	cc, err := readersToObject(func() (map[string]interface{}, error) {
@@ -47,3 +50,63 @@ func TestAuthorizedKeys(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMergeDirectives(t *testing.T) {
+	c1 := map[string]interface{}{
+		"ssh_authorized_keys": []interface{}{
+			"one...",
+		},
+		"run_cmd": []interface{}{
+			"echo one",
+		},
+		"k3os": map[string]interface{}{
+			"modules": []interface{}{"kvm", "nvme"},
+			"sysctls": map[string]interface{}{
+				"kernel.printk": "4 4 1 7",
+			},
+		},
+	}
+	c2 := map[string]interface{}{
+		"$merge": map[string]interface{}{
+			"ssh_authorized_keys": "append",
+			"runcmd":              "prepend",
+		},
+		"ssh_authorized_keys": []interface{}{
+			"two...",
+		},
+		"run_cmd": "echo two",
+		"k3os": map[string]interface{}{
+			"$merge": map[string]interface{}{
+				"module": "unique-append",
+				"sysctl": "append",
+			},
+			"modules": []interface{}{"nvme", "zfs"},
+			"sysctls": map[string]interface{}{
+				"kernel.kptr_restrict": "1",
+			},
+		},
+	}
+	cc, err := readersToObject(
+		func() (map[string]interface{}, error) {
+			return c1, nil
+		},
+		func() (map[string]interface{}, error) {
+			return c2, nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cc.SSHAuthorizedKeys, []string{"one...", "two..."}) {
+		t.Errorf("unexpected keys %v", cc.SSHAuthorizedKeys)
+	}
+	if !reflect.DeepEqual(cc.Runcmd, []string{"echo two", "echo one"}) {
+		t.Errorf("unexpected run_cmd %v", cc.Runcmd)
+	}
+	if !reflect.DeepEqual(cc.K3OS.Modules, []string{"kvm", "nvme", "zfs"}) {
+		t.Errorf("unexpected modules %v", cc.K3OS.Modules)
+	}
+	if len(cc.K3OS.Sysctls) != 2 {
+		t.Errorf("unexpected sysctls %v", cc.K3OS.Sysctls)
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"reflect"
+
+	"github.com/rancher/mapper"
+	"github.com/rancher/mapper/definition"
+)
+
+// Redacted replaces the value of secret fields when the configuration is printed
+const Redacted = "<redacted>"
+
+// secretFields are the fields declared with the `secret:"true"` struct tag, keyed by schema ID and field name
+var secretFields = map[string]bool{}
+
+func init() {
+	forEachField(reflect.TypeOf(CloudConfig{}), func(id string, f reflect.StructField) {
+		if f.Tag.Get("secret") == "true" {
+			secretFields[id+"."+jsonName(f)] = true
+		}
+	})
+}
+
+// Redact replaces the values of secret fields in the encoded configuration with Redacted, references to files and
+// environment variables are kept as they do not hold the secret
+func Redact(data map[string]interface{}) {
+	walkSecrets(schema, data, func(data map[string]interface{}, name string) {
+		if _, _, ok := reference(data[name]); ok {
+			return
+		}
+		if val, ok := data[name].(string); ok && val == "" {
+			return
+		}
+		data[name] = Redacted
+	})
+}
+
+// stripSecrets removes the secret fields from the encoded configuration
+func stripSecrets(data map[string]interface{}) {
+	walkSecrets(schema, data, func(data map[string]interface{}, name string) {
+		delete(data, name)
+	})
+}
+
+func walkSecrets(s *mapper.Schema, data map[string]interface{}, fn func(data map[string]interface{}, name string)) {
+	for name, field := range s.ResourceFields {
+		val, ok := data[name]
+		if !ok {
+			continue
+		}
+		if secretFields[s.ID+"."+name] {
+			fn(data, name)
+			continue
+		}
+
+		fieldType := field.Type
+		isMap := definition.IsMapType(fieldType)
+		if isMap || definition.IsArrayType(fieldType) {
+			fieldType = definition.SubType(fieldType)
+		}
+		sub := schemas.Schema(fieldType)
+		if sub == nil {
+			continue
+		}
+		switch v := val.(type) {
+		case map[string]interface{}:
+			if !isMap {
+				walkSecrets(sub, v, fn)
+				continue
+			}
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					walkSecrets(sub, m, fn)
+				}
+			}
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					walkSecrets(sub, m, fn)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rancher/mapper/convert"
+)
+
+func TestRedact(t *testing.T) {
+	cfg := CloudConfig{
+		Hostname: "node",
+		WriteFiles: []File{
+			{Path: "/etc/secret", Content: "data"},
+		},
+		K3OS: K3OS{
+			Token:    "K10secret",
+			Password: "rancher",
+			Wifi: []Wifi{
+				{Name: "home", Passphrase: "wifi-secret"},
+			},
+			Install: &Install{Device: "/dev/sda"},
+			Registries: &Registries{
+				Configs: map[string]RegistryConfig{
+					"registry.local": {Auth: &RegistryAuth{Username: "me", Password: "registry-secret"}},
+				},
+			},
+		},
+	}
+
+	data, err := convert.EncodeToMap(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data["k3os"].(map[string]interface{})["password"] = map[string]interface{}{"fromEnv": "PASSWORD"}
+	Redact(data)
+
+	k3os := data["k3os"].(map[string]interface{})
+	if k3os["token"] != Redacted {
+		t.Errorf("token was not redacted: %v", k3os["token"])
+	}
+	if _, _, ok := reference(k3os["password"]); !ok {
+		t.Errorf("password reference was redacted: %v", k3os["password"])
+	}
+	if wifi := k3os["wifi"].([]interface{})[0].(map[string]interface{}); wifi["passphrase"] != Redacted || wifi["name"] != "home" {
+		t.Errorf("wifi was not redacted: %v", wifi)
+	}
+	if file := data["writeFiles"].([]interface{})[0].(map[string]interface{}); file["content"] != Redacted || file["path"] != "/etc/secret" {
+		t.Errorf("file content was not redacted: %v", file)
+	}
+	registry := k3os["registries"].(map[string]interface{})["configs"].(map[string]interface{})["registry.local"]
+	if auth := registry.(map[string]interface{})["auth"].(map[string]interface{}); auth["password"] != Redacted || auth["username"] != "me" {
+		t.Errorf("registry password was not redacted: %v", auth)
+	}
+	if data["hostname"] != "node" {
+		t.Errorf("hostname was redacted: %v", data["hostname"])
+	}
+
+	env, err := ToEnv(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(env, "\n")
+	if strings.Contains(joined, "K10secret") || strings.Contains(joined, "rancher") || strings.Contains(joined, "registry-secret") {
+		t.Errorf("secrets in environment: %v", env)
+	}
+	if !strings.Contains(joined, "K3OS_INSTALL_DEVICE=/dev/sda") {
+		t.Errorf("install device missing from environment: %v", env)
+	}
+}
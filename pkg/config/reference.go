@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rancher/mapper/convert"
+)
+
+const (
+	// FromFile references a value by the path of the file that contains it, for example
+	//   token:
+	//     fromFile: /var/lib/rancher/k3os/token
+	FromFile = "fromFile"
+	// FromEnv references a value by the name of the environment variable that contains it
+	FromEnv = "fromEnv"
+)
+
+var referenceKinds = map[string]string{
+	FromFile:    FromFile,
+	"from_file": FromFile,
+	FromEnv:     FromEnv,
+	"from_env":  FromEnv,
+}
+
+// reference returns the kind and target of a reference to a file or environment variable
+func reference(val interface{}) (string, string, bool) {
+	m, ok := val.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return "", "", false
+	}
+	for k, v := range m {
+		kind, ok := referenceKinds[k]
+		if !ok {
+			return "", "", false
+		}
+		target, ok := v.(string)
+		return kind, target, ok
+	}
+	return "", "", false
+}
+
+// resolvedReference is a reference that was replaced by its value, path is made of field names and list indexes
+type resolvedReference struct {
+	path []interface{}
+	ref  interface{}
+}
+
+type resolver struct {
+	resolved []resolvedReference
+}
+
+// resolve replaces every reference in data with the content of the file or environment variable
+func (r *resolver) resolve(path []interface{}, data interface{}) (interface{}, error) {
+	if kind, target, ok := reference(data); ok {
+		val, err := resolveReference(kind, target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", referencePath(path), err)
+		}
+		r.resolved = append(r.resolved, resolvedReference{
+			path: path,
+			ref:  data,
+		})
+		return val, nil
+	}
+
+	switch v := data.(type) {
+	case []interface{}:
+		for i, item := range v {
+			val, err := r.resolve(appendPath(path, i), item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = val
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			val, err := r.resolve(appendPath(path, k), item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = val
+		}
+	}
+	return data, nil
+}
+
+func resolveReference(kind, target string) (string, error) {
+	switch kind {
+	case FromFile:
+		bytes, err := ioutil.ReadFile(target)
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%s %s: file does not exist", kind, target)
+		} else if err != nil {
+			return "", fmt.Errorf("%s %s: %v", kind, target, err)
+		}
+		return strings.TrimRight(string(bytes), "\r\n"), nil
+	case FromEnv:
+		val, ok := os.LookupEnv(target)
+		if !ok {
+			return "", fmt.Errorf("%s %s: environment variable is not set", kind, target)
+		}
+		return val, nil
+	}
+	return "", fmt.Errorf("unknown reference %s", kind)
+}
+
+// unresolve puts the references back in data, which is the encoded form of the configuration they were resolved in
+func (r *resolver) unresolve(data map[string]interface{}) {
+	for _, resolved := range r.resolved {
+		var parent interface{} = data
+		for i, key := range resolved.path {
+			last := i == len(resolved.path)-1
+			switch p := parent.(type) {
+			case map[string]interface{}:
+				k, _ := key.(string)
+				if last {
+					p[k] = resolved.ref
+				}
+				parent = p[k]
+			case []interface{}:
+				idx, ok := key.(int)
+				if !ok || idx >= len(p) {
+					parent = nil
+					continue
+				}
+				if last {
+					p[idx] = resolved.ref
+				}
+				parent = p[idx]
+			default:
+				parent = nil
+			}
+		}
+	}
+}
+
+func appendPath(path []interface{}, key interface{}) []interface{} {
+	return append(append([]interface{}{}, path...), key)
+}
+
+func referencePath(path []interface{}) string {
+	result := ""
+	for _, key := range path {
+		switch k := key.(type) {
+		case int:
+			result += fmt.Sprintf("[%d]", k)
+		case string:
+			result = joinPath(result, convert.ToYAMLKey(k))
+		}
+	}
+	return result
+}
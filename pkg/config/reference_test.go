@@ -0,0 +1,75 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/mapper/convert"
+)
+
+func TestReferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reference")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("K10secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("K3OS_TEST_PASSPHRASE", "wifi-secret")
+	defer os.Unsetenv("K3OS_TEST_PASSPHRASE")
+
+	src := source{"test", func() (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"k3os": map[string]interface{}{
+				"token": map[string]interface{}{"fromFile": tokenFile},
+				"wifi": []interface{}{
+					map[string]interface{}{
+						"name":       "home",
+						"passphrase": map[string]interface{}{"from_env": "K3OS_TEST_PASSPHRASE"},
+					},
+				},
+				"labels": map[string]interface{}{
+					"plain":  "value",
+					"secret": map[string]interface{}{"fromFile": tokenFile},
+				},
+			},
+		}, nil
+	}}
+
+	cc, r, err := resolveSources(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.K3OS.Token != "K10secret" || cc.K3OS.Wifi[0].Passphrase != "wifi-secret" || cc.K3OS.Labels["secret"] != "K10secret" {
+		t.Fatalf("references were not resolved: %+v", cc.K3OS)
+	}
+
+	data, err := convert.EncodeToMap(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.unresolve(data)
+	k3os := data["k3os"].(map[string]interface{})
+	if !reflect.DeepEqual(k3os["token"], map[string]interface{}{"fromFile": tokenFile}) {
+		t.Errorf("token reference was not kept, got %v", k3os["token"])
+	}
+	wifi := k3os["wifi"].([]interface{})[0].(map[string]interface{})
+	if !reflect.DeepEqual(wifi["passphrase"], map[string]interface{}{"from_env": "K3OS_TEST_PASSPHRASE"}) {
+		t.Errorf("passphrase reference was not kept, got %v", wifi["passphrase"])
+	}
+
+	missing := filepath.Join(dir, "missing")
+	_, err = ToConfig(map[string]interface{}{
+		"k3os": map[string]interface{}{"token": map[string]interface{}{"fromFile": missing}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "k3os.token: fromFile "+missing+": file does not exist") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
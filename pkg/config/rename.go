@@ -41,8 +41,10 @@ func (f *FuzzyNames) ModifySchema(schema *mapper.Schema, schemas *mapper.Schemas
 		f.addName(name, name)
 	}
 
-	f.names["pass"] = "passphrase"
-	f.names["password"] = "passphrase"
+	if _, ok := schema.ResourceFields["passphrase"]; ok {
+		f.names["pass"] = "passphrase"
+		f.names["password"] = "passphrase"
+	}
 
 	return nil
 }
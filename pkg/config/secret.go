@@ -0,0 +1,188 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/k3os/pkg/system"
+	"github.com/rancher/k3os/pkg/util"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// SealedPrefix marks a string value that is encrypted with Seal for the key of a node
+const SealedPrefix = "enc:"
+
+// SecretKey is the node-local private key used to decrypt sealed values
+var SecretKey = system.LocalPath("secret.key")
+
+const keySize = 32
+
+// Seal encrypts value for the base64 encoded public key with a NaCl box from a throwaway key pair, the result can be
+// used in place of the value in any configuration source
+func Seal(value, publicKey string) (string, error) {
+	peer, err := decodeKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %v", err)
+	}
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+
+	out := append(pub[:], nonce[:]...)
+	out = box.Seal(out, []byte(value), &nonce, peer, priv)
+	return SealedPrefix + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// IsSealed returns true if the value was encrypted with Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, SealedPrefix)
+}
+
+// PublicKey returns the base64 encoded public key of this node, the private key is generated in SecretKey if it does
+// not exist yet
+func PublicKey() (string, error) {
+	priv, err := readSecretKey()
+	if os.IsNotExist(err) {
+		priv, err = generateSecretKey()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var pub [keySize]byte
+	curve25519.ScalarBaseMult(&pub, priv)
+	return base64.StdEncoding.EncodeToString(pub[:]), nil
+}
+
+func generateSecretKey() (*[keySize]byte, error) {
+	_, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(SecretKey), 0700); err != nil {
+		return nil, err
+	}
+	content := base64.StdEncoding.EncodeToString(priv[:]) + "\n"
+	return priv, util.WriteFileAtomic(SecretKey, []byte(content), 0600)
+}
+
+func readSecretKey() (*[keySize]byte, error) {
+	bytes, err := ioutil.ReadFile(SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodeKey(string(bytes))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in %s: %v", SecretKey, err)
+	}
+	return key, nil
+}
+
+func decodeKey(s string) (*[keySize]byte, error) {
+	bytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes) != keySize {
+		return nil, fmt.Errorf("expected %d bytes, got %d", keySize, len(bytes))
+	}
+	var key [keySize]byte
+	copy(key[:], bytes)
+	return &key, nil
+}
+
+// unsealer decrypts sealed values, the private key is only read once a sealed value is found
+type unsealer struct {
+	key *[keySize]byte
+}
+
+func (u *unsealer) open(value string) (string, error) {
+	if u.key == nil {
+		key, err := readSecretKey()
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no private key in %s to decrypt it", SecretKey)
+		} else if err != nil {
+			return "", err
+		}
+		u.key = key
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, SealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < keySize+24+box.Overhead {
+		return "", fmt.Errorf("sealed value is too short")
+	}
+	var (
+		peer  [keySize]byte
+		nonce [24]byte
+	)
+	copy(peer[:], data[:keySize])
+	copy(nonce[:], data[keySize:keySize+24])
+	out, ok := box.Open(nil, data[keySize+24:], &nonce, &peer, u.key)
+	if !ok {
+		return "", fmt.Errorf("failed to decrypt, it was not sealed for the key in %s", SecretKey)
+	}
+	return string(out), nil
+}
+
+// unseal replaces every sealed string in data with its decrypted value
+func (u *unsealer) unseal(path string, data interface{}) (interface{}, error) {
+	switch v := data.(type) {
+	case string:
+		if !IsSealed(v) {
+			return v, nil
+		}
+		result, err := u.open(v)
+		if err != nil {
+			return nil, fmt.Errorf("sealed value %s: %v", path, err)
+		}
+		return result, nil
+	case []string:
+		for i, item := range v {
+			result, err := u.unseal(fmt.Sprintf("%s[%d]", path, i), item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = result.(string)
+		}
+	case []interface{}:
+		for i, item := range v {
+			result, err := u.unseal(fmt.Sprintf("%s[%d]", path, i), item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = result
+		}
+	case map[string]string:
+		for k, item := range v {
+			result, err := u.unseal(joinPath(path, k), item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = result.(string)
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			result, err := u.unseal(joinPath(path, k), item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = result
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(key string) { SecretKey = key }(SecretKey)
+	SecretKey = filepath.Join(dir, "secret.key")
+
+	pub, err := PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := Seal("K10secret", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase, err := Seal("wifi-secret", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := readersToObject(func() (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"k3os": map[string]interface{}{
+				"token": token,
+				"wifi": []interface{}{
+					map[string]interface{}{"name": "home", "passphrase": passphrase},
+				},
+			},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.K3OS.Token != "K10secret" {
+		t.Errorf("token %q was not decrypted", cc.K3OS.Token)
+	}
+	if cc.K3OS.Wifi[0].Passphrase != "wifi-secret" {
+		t.Errorf("passphrase %q was not decrypted", cc.K3OS.Wifi[0].Passphrase)
+	}
+
+	// a value sealed for another node can not be decrypted
+	other, err := Seal("K10secret", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ToConfig(map[string]interface{}{"k3os": map[string]interface{}{"token": other}}); err == nil {
+		t.Error("expected an error for a value sealed for another key")
+	}
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/rancher/k3os/pkg/mode"
+)
+
+// templateHeader on the first line of a config file or cloud-config user-data turns on Go template rendering of
+// the rest of the file with the node Facts, for example
+//
+//	## template: go
+//	hostname: edge-{{ .MAC | short }}
+const templateHeader = "## template: go"
+
+var (
+	sysDMI     = "/sys/class/dmi/id"
+	procRoute  = "/proc/net/route"
+	procMem    = "/proc/meminfo"
+	instanceID = "/run/config/instance_id"
+
+	facts     Facts
+	factsErr  error
+	factsOnce sync.Once
+)
+
+// Facts are the properties of the node available to config templates
+type Facts struct {
+	Interface  string `json:"interface,omitempty"`
+	MAC        string `json:"mac,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	DMI        DMI    `json:"dmi"`
+	CPUs       int    `json:"cpus"`
+	MemoryMB   int    `json:"memoryMB"`
+	Arch       string `json:"arch"`
+	Mode       string `json:"mode,omitempty"`
+	InstanceID string `json:"instanceId,omitempty"`
+}
+
+// DMI is the hardware identification read from the firmware
+type DMI struct {
+	Serial  string `json:"serial,omitempty"`
+	Product string `json:"product,omitempty"`
+	Vendor  string `json:"vendor,omitempty"`
+	UUID    string `json:"uuid,omitempty"`
+}
+
+var templateFuncs = template.FuncMap{
+	"short":   shortMAC,
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"default": func(def, val string) string {
+		if val == "" {
+			return def
+		}
+		return val
+	},
+}
+
+// GetFacts returns the facts of this node, they are only gathered once
+func GetFacts() (Facts, error) {
+	factsOnce.Do(func() {
+		facts, factsErr = gatherFacts()
+	})
+	return facts, factsErr
+}
+
+func gatherFacts() (Facts, error) {
+	f := Facts{
+		CPUs: runtime.NumCPU(),
+		Arch: runtime.GOARCH,
+		DMI: DMI{
+			Serial:  readFact(filepath.Join(sysDMI, "product_serial")),
+			Product: readFact(filepath.Join(sysDMI, "product_name")),
+			Vendor:  readFact(filepath.Join(sysDMI, "sys_vendor")),
+			UUID:    readFact(filepath.Join(sysDMI, "product_uuid")),
+		},
+		InstanceID: readFact(instanceID),
+	}
+
+	var err error
+	if f.Mode, err = mode.Get(); err != nil {
+		return f, err
+	}
+	if f.MemoryMB, err = memoryMB(); err != nil {
+		return f, err
+	}
+
+	iface, err := primaryInterface()
+	if err != nil || iface == nil {
+		return f, err
+	}
+	f.Interface = iface.Name
+	f.MAC = iface.HardwareAddr.String()
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return f, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			f.IP = ipnet.IP.String()
+			break
+		}
+	}
+	return f, nil
+}
+
+func readFact(path string) string {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes))
+}
+
+func memoryMB() (int, error) {
+	f, err := os.Open(procMem)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, err
+			}
+			return kb / 1024, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// primaryInterface is the interface of the default route, or the first interface with a MAC address that is up
+func primaryInterface() (*net.Interface, error) {
+	bytes, err := ioutil.ReadFile(procRoute)
+	if err == nil {
+		for _, line := range strings.Split(string(bytes), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) > 1 && fields[1] == "00000000" {
+				if iface, err := net.InterfaceByName(fields[0]); err == nil && len(iface.HardwareAddr) > 0 {
+					return iface, nil
+				}
+			}
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 && len(iface.HardwareAddr) > 0 {
+			iface := iface
+			return &iface, nil
+		}
+	}
+	return nil, nil
+}
+
+// shortMAC returns the last three bytes of a MAC address without separators, "52:54:00:12:34:56" becomes "123456"
+func shortMAC(mac string) string {
+	s := strings.Replace(strings.Replace(mac, ":", "", -1), "-", "", -1)
+	if len(s) > 6 {
+		s = s[len(s)-6:]
+	}
+	return strings.ToLower(s)
+}
+
+func isTemplate(data []byte) bool {
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+	return strings.TrimSpace(string(line)) == templateHeader
+}
+
+// renderTemplate renders data with the node facts if it starts with templateHeader, otherwise it is returned as is
+func renderTemplate(name string, data []byte) ([]byte, error) {
+	if !isTemplate(data) {
+		return data, nil
+	}
+
+	facts, err := GetFacts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather node facts: %v", err)
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, facts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
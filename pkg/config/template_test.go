@@ -0,0 +1,61 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	factsOnce.Do(func() {
+		facts = Facts{
+			MAC: "52:54:00:AB:CD:EF",
+			DMI: DMI{Serial: "SN1234"},
+		}
+	})
+
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	templated := filepath.Join(dir, "templated.yaml")
+	if err := ioutil.WriteFile(templated, []byte(`## template: go
+hostname: edge-{{ .MAC | short }}
+k3os:
+  labels:
+    rack: "{{ .DMI.Serial }}"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain.yaml")
+	if err := ioutil.WriteFile(plain, []byte(`write_files:
+- path: /etc/motd
+  content: "{{ .MAC }}"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := readersToObject(
+		func() (map[string]interface{}, error) { return readFile(templated) },
+		func() (map[string]interface{}, error) { return readFile(plain) },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.Hostname != "edge-abcdef" {
+		t.Errorf("hostname %q != edge-abcdef", cc.Hostname)
+	}
+	if cc.K3OS.Labels["rack"] != "SN1234" {
+		t.Errorf("rack label %q != SN1234", cc.K3OS.Labels["rack"])
+	}
+	if cc.WriteFiles[0].Content != "{{ .MAC }}" {
+		t.Errorf("file without the template header was rendered: %q", cc.WriteFiles[0].Content)
+	}
+
+	if _, err := renderTemplate("bad", []byte("## template: go\nhostname: {{ .Missing }}\n")); err == nil {
+		t.Error("expected an error for an unknown fact")
+	}
+}
@@ -0,0 +1,616 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/k3os/pkg/util"
+	"github.com/rancher/mapper"
+	"github.com/rancher/mapper/convert"
+	"github.com/rancher/mapper/definition"
+)
+
+// ValidationError is a single problem found in a configuration source
+type ValidationError struct {
+	Source  string `json:"source"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v ValidationError) Error() string {
+	if v.Field == "" {
+		return fmt.Sprintf("%s: %s", v.Source, v.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", v.Source, v.Field, v.Message)
+}
+
+// valueValidators check the content of string values, keyed by schema ID and field name
+var valueValidators = map[string]func(string) error{
+	"file.permissions":             validatePermissions,
+	"k3OS.serverUrl":               validateServerURL,
+	"k3OS.taints":                  validateTaint,
+	"k3S.nodeIp":                   validateIP,
+	"k3S.nodeExternalIp":           validateIP,
+	"k3S.clusterCidr":              validateCIDR,
+	"k3S.serviceCidr":              validateCIDR,
+	"k3S.clusterDns":               validateIP,
+	"k3S.flannelBackend":           validateFlannelBackend,
+	"imageTarball.sha256":          validateSHA256,
+	"user.name":                    validateName,
+	"user.groups":                  validateName,
+	"user.gecos":                   validatePasswdField,
+	"user.home":                    validatePasswdField,
+	"user.shell":                   validatePasswdField,
+	"user.hashedPassword":          validatePasswdField,
+	"group.name":                   validateName,
+	"group.members":                validateName,
+	"networkInterface.mac":         validateMAC,
+	"networkInterface.addresses":   validateAddress,
+	"networkInterface.gateway":     validateIP,
+	"networkInterface.gateway6":    validateIP,
+	"networkInterface.nameservers": validateIP,
+	"route.to":                     validateCIDR,
+	"route.via":                    validateIP,
+	"bond.mode":                    validateBondMode,
+	"proxy.httpProxy":              validateProxyURL,
+	"proxy.httpsProxy":             validateProxyURL,
+	"cloudConfig.caCerts":          validateCACert,
+	"disk.filesystem":              validateFilesystem,
+	"mount.path":                   validateAbsPath,
+	"swap.file":                    validateAbsPath,
+	"swap.fileSize":                validateSize,
+	"zram.size":                    validateZramSize,
+	"timeSync.makestep":            validateMakestep,
+	"timeSync.timezone":            validateTimezone,
+}
+
+// Validate runs every configuration reader and reports unknown keys, type errors and invalid values
+func Validate() []ValidationError {
+	return validateSources(sources()...)
+}
+
+// ValidateFiles is like Validate but only checks the given files
+func ValidateFiles(paths ...string) []ValidationError {
+	var srcs []source
+	for _, p := range paths {
+		p := p
+		srcs = append(srcs, source{p, func() (map[string]interface{}, error) {
+			return readFile(p)
+		}})
+	}
+	return validateSources(srcs...)
+}
+
+func validateSources(srcs ...source) []ValidationError {
+	var result []ValidationError
+
+	for _, s := range srcs {
+		data, err := s.read()
+		if err != nil {
+			result = append(result, ValidationError{Source: s.name, Message: err.Error()})
+			continue
+		}
+		if _, err := migrate(data); err != nil {
+			result = append(result, ValidationError{Source: s.name, Message: err.Error()})
+			continue
+		}
+		if err := foldK3SAliases(data); err != nil {
+			result = append(result, ValidationError{Source: s.name, Message: err.Error()})
+			continue
+		}
+		if s.name == cmdline {
+			// the kernel cmdline is shared with everything else that boots, only known keys are ours
+			data = knownKeys(schema, data)
+		}
+		v := validator{source: s.name}
+		v.validateObject("", schema, data)
+		result = append(result, v.errors...)
+	}
+
+	// the individual sources can be fine and still not merge into a valid config
+	// sealed values are left as they are, the key to decrypt them is usually only on the node
+	if len(result) == 0 {
+		data, err := merge(nil, srcs...)
+		if err == nil {
+			err = convert.ToObj(data, &CloudConfig{})
+		}
+		if err != nil {
+			result = append(result, ValidationError{Source: "merged config", Message: err.Error()})
+		}
+	}
+
+	return result
+}
+
+type validator struct {
+	source string
+	errors []ValidationError
+}
+
+func (v *validator) errorf(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, ValidationError{
+		Source:  v.source,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validateObject(path string, s *mapper.Schema, data map[string]interface{}) {
+	names := fuzzyNames(s)
+
+	for _, k := range sortedKeys(data) {
+		fieldPath := joinPath(path, k)
+		if k == mergeKey {
+			v.validateMergeDirectives(fieldPath, s, data[k])
+			continue
+		}
+		name := k
+		if _, ok := s.ResourceFields[name]; !ok {
+			name = names[k]
+		}
+		field, ok := s.ResourceFields[name]
+		if !ok {
+			if suggestion := suggest(k, s); suggestion != "" {
+				v.errorf(fieldPath, "unknown key, did you mean %q?", suggestion)
+			} else {
+				v.errorf(fieldPath, "unknown key")
+			}
+			continue
+		}
+		v.validateValue(fieldPath, s.ID+"."+name, field.Type, data[k])
+	}
+}
+
+func (v *validator) validateValue(path, fieldID, fieldType string, val interface{}) {
+	if val == nil {
+		return
+	}
+
+	switch {
+	case fieldType == "string":
+		if _, _, ok := reference(val); ok {
+			return
+		}
+		str, ok := val.(string)
+		if !ok {
+			v.errorf(path, "expected a string, got %s (quote the value)", typeName(val))
+			return
+		}
+		v.validateString(path, fieldID, str)
+	case fieldType == "boolean":
+		switch b := val.(type) {
+		case bool:
+		case string:
+			if b != "true" && b != "false" {
+				v.errorf(path, "expected true or false, got %q", b)
+			}
+		default:
+			v.errorf(path, "expected a boolean, got %s", typeName(val))
+		}
+	case fieldType == "int":
+		if _, err := convert.ToNumber(val); err != nil {
+			v.errorf(path, "expected a number, got %s", typeName(val))
+		}
+	case fieldType == "array[string]":
+		if str, ok := val.(string); ok {
+			v.validateString(path, fieldID, str)
+			return
+		}
+		items, ok := toSlice(val)
+		if !ok {
+			v.errorf(path, "expected a list of strings, got %s", typeName(val))
+			return
+		}
+		for i, item := range items {
+			if _, _, ok := reference(item); ok {
+				continue
+			}
+			str, ok := item.(string)
+			if !ok {
+				v.errorf(fmt.Sprintf("%s[%d]", path, i), "expected a string, got %s (quote the value)", typeName(item))
+				continue
+			}
+			v.validateString(fmt.Sprintf("%s[%d]", path, i), fieldID, str)
+		}
+	case fieldType == "map[string]":
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			v.errorf(path, "expected a map, got %s", typeName(val))
+			return
+		}
+		for _, k := range sortedKeys(m) {
+			if _, _, ok := reference(m[k]); ok {
+				continue
+			}
+			switch item := m[k]; item.(type) {
+			case map[string]interface{}, []interface{}:
+				v.errorf(joinPath(path, k), "expected a scalar value, got %s", typeName(item))
+			}
+		}
+	case definition.IsArrayType(fieldType):
+		items, ok := toSlice(val)
+		if !ok {
+			v.errorf(path, "expected a list, got %s", typeName(val))
+			return
+		}
+		subType := definition.SubType(fieldType)
+		for i, item := range items {
+			v.validateValue(fmt.Sprintf("%s[%d]", path, i), fieldID, subType, item)
+		}
+	case definition.IsMapType(fieldType):
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			v.errorf(path, "expected a map, got %s", typeName(val))
+			return
+		}
+		subType := definition.SubType(fieldType)
+		for _, k := range sortedKeys(m) {
+			v.validateValue(joinPath(path, k), fieldID, subType, m[k])
+		}
+	default:
+		sub := schemas.Schema(fieldType)
+		if sub == nil {
+			return
+		}
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			v.errorf(path, "expected a map, got %s", typeName(val))
+			return
+		}
+		v.validateObject(path, sub, m)
+		if sub.ID == "file" {
+			v.validateFile(path, m)
+		}
+	}
+}
+
+func (v *validator) validateMergeDirectives(path string, s *mapper.Schema, val interface{}) {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		v.errorf(path, "expected a map of field names to merge directives, got %s", typeName(val))
+		return
+	}
+	names := fuzzyNames(s)
+	for _, k := range sortedKeys(m) {
+		if _, ok := s.ResourceFields[k]; !ok {
+			if _, ok := names[k]; !ok {
+				v.errorf(joinPath(path, k), "unknown key")
+				continue
+			}
+		}
+		if directive := convert.ToString(m[k]); !isMergeDirective(directive) {
+			v.errorf(joinPath(path, k), "invalid merge directive %q, must be one of %s", directive,
+				strings.Join(mergeDirectives, ", "))
+		}
+	}
+}
+
+func (v *validator) validateString(path, fieldID, val string) {
+	if f, ok := valueValidators[fieldID]; ok {
+		if err := f(val); err != nil {
+			v.errorf(path, "%v", err)
+		}
+	}
+}
+
+func (v *validator) validateFile(path string, data map[string]interface{}) {
+	content, _ := data["content"].(string)
+	encoding, _ := data["encoding"].(string)
+	if _, err := util.DecodeContent(content, encoding); err != nil {
+		v.errorf(joinPath(path, "content"), "%v", err)
+	}
+	if p, _ := data["path"].(string); p == "" {
+		v.errorf(joinPath(path, "path"), "path is required")
+	}
+}
+
+func validatePermissions(val string) error {
+	f := File{RawFilePermissions: val}
+	perm, err := f.Permissions()
+	if err != nil {
+		return err
+	}
+	if perm > 07777 {
+		return fmt.Errorf("file permissions %q out of range", val)
+	}
+	return nil
+}
+
+func validateServerURL(val string) error {
+	u, err := url.Parse(val)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("%q is not an http or https URL", val)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", val)
+	}
+	return nil
+}
+
+func validateCACert(val string) error {
+	_, err := util.ParseCertificates([]byte(val))
+	return err
+}
+
+// filesystems are those the disks may be formatted with
+var filesystems = []string{"ext2", "ext3", "ext4", "xfs", "btrfs", "vfat"}
+
+func validateFilesystem(val string) error {
+	for _, fs := range filesystems {
+		if val == fs {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported filesystem %q, must be one of %s", val, strings.Join(filesystems, ", "))
+}
+
+func validateSize(val string) error {
+	_, err := util.ParseSize(val)
+	return err
+}
+
+func validateZramSize(val string) error {
+	if strings.HasSuffix(val, "%") {
+		if percent, err := strconv.Atoi(strings.TrimSuffix(val, "%")); err != nil || percent <= 0 || percent > 100 {
+			return fmt.Errorf("%q is not a percentage of the memory", val)
+		}
+		return nil
+	}
+	return validateSize(val)
+}
+
+func validateMakestep(val string) error {
+	_, _, err := parseMakestep(val)
+	return err
+}
+
+var timezoneRegexp = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+func validateTimezone(val string) error {
+	if !timezoneRegexp.MatchString(val) {
+		return fmt.Errorf("%q is not a timezone such as Europe/Berlin", val)
+	}
+	return nil
+}
+
+func validateAbsPath(val string) error {
+	if !strings.HasPrefix(val, "/") {
+		return fmt.Errorf("%q is not an absolute path", val)
+	}
+	return nil
+}
+
+func validateProxyURL(val string) error {
+	if !strings.Contains(val, "://") {
+		val = "http://" + val
+	}
+	u, err := url.Parse(val)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("%q is not an http, https or socks5 URL", val)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", val)
+	}
+	return nil
+}
+
+func validateIP(val string) error {
+	if net.ParseIP(val) == nil {
+		return fmt.Errorf("%q is not an IP address", val)
+	}
+	return nil
+}
+
+func validateCIDR(val string) error {
+	if _, _, err := net.ParseCIDR(val); err != nil {
+		return fmt.Errorf("%q is not a CIDR such as 10.42.0.0/16", val)
+	}
+	return nil
+}
+
+func validateAddress(val string) error {
+	if _, _, err := net.ParseCIDR(val); err != nil {
+		return fmt.Errorf("%q is not an address with a prefix length such as 10.0.0.2/24", val)
+	}
+	return nil
+}
+
+func validateMAC(val string) error {
+	if _, err := net.ParseMAC(val); err != nil {
+		return fmt.Errorf("%q is not a MAC address", val)
+	}
+	return nil
+}
+
+func validateBondMode(val string) error {
+	for _, mode := range BondModes {
+		if val == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown bond mode %q, must be one of %s", val, strings.Join(BondModes, ", "))
+}
+
+var flannelBackends = []string{"none", "vxlan", "ipsec", "host-gw", "wireguard"}
+
+func validateFlannelBackend(val string) error {
+	for _, backend := range flannelBackends {
+		if val == backend {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown flannel backend %q, must be one of %s", val, strings.Join(flannelBackends, ", "))
+}
+
+var nameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+
+func validateName(val string) error {
+	if len(val) > 32 || !nameRegexp.MatchString(val) {
+		return fmt.Errorf("%q is not a valid user or group name", val)
+	}
+	return nil
+}
+
+// validatePasswdField checks a value written to a colon separated line of /etc/passwd or /etc/shadow, a sealed hashed
+// password is checked once it is decrypted
+func validatePasswdField(val string) error {
+	if !strings.HasPrefix(val, SealedPrefix) && strings.ContainsAny(val, ":\n\r") {
+		return fmt.Errorf("%q must not contain a colon or a line break", val)
+	}
+	return nil
+}
+
+func validateSHA256(val string) error {
+	if len(val) != 64 || strings.Trim(strings.ToLower(val), "0123456789abcdef") != "" {
+		return fmt.Errorf("%q is not a hex encoded sha256 checksum", val)
+	}
+	return nil
+}
+
+func validateTaint(val string) error {
+	i := strings.LastIndex(val, ":")
+	if i < 0 {
+		return fmt.Errorf("taint %q must be in the form key[=value]:effect", val)
+	}
+	keyValue, effect := val[:i], val[i+1:]
+	switch effect {
+	case "NoSchedule", "PreferNoSchedule", "NoExecute":
+	default:
+		return fmt.Errorf("taint %q has invalid effect %q, must be NoSchedule, PreferNoSchedule or NoExecute", val, effect)
+	}
+	if key := strings.SplitN(keyValue, "=", 2)[0]; key == "" {
+		return fmt.Errorf("taint %q has an empty key", val)
+	}
+	return nil
+}
+
+func knownKeys(s *mapper.Schema, data map[string]interface{}) map[string]interface{} {
+	names := fuzzyNames(s)
+	result := map[string]interface{}{}
+	for k, v := range data {
+		if _, ok := s.ResourceFields[k]; ok {
+			result[k] = v
+		} else if _, ok := names[k]; ok {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// fuzzyNames returns the alternate names accepted for the fields of a schema
+func fuzzyNames(s *mapper.Schema) map[string]string {
+	f := &FuzzyNames{}
+	_ = f.ModifySchema(s, schemas)
+	result := map[string]string{}
+	for k, v := range f.names {
+		if _, ok := s.ResourceFields[v]; ok {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// suggest returns the closest known field name, in YAML form, to an unknown key
+func suggest(key string, s *mapper.Schema) string {
+	var (
+		best     string
+		bestDist = len(key)/3 + 2
+	)
+	lower := strings.ToLower(convert.ToYAMLKey(key))
+	for name := range s.ResourceFields {
+		yamlName := convert.ToYAMLKey(name)
+		if d := levenshtein(lower, yamlName); d < bestDist || (d == bestDist && yamlName < best) {
+			best, bestDist = yamlName, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
+
+func toSlice(val interface{}) ([]interface{}, bool) {
+	switch v := val.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		result := make([]interface{}, len(v))
+		for i, s := range v {
+			result[i] = s
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+func typeName(val interface{}) string {
+	switch val.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case map[string]interface{}:
+		return "a map"
+	case []interface{}, []string:
+		return "a list"
+	case nil:
+		return "null"
+	}
+	return "a number"
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	var keys []string
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	errs := validateSources(source{"test", func() (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"ssh_authorized_key": "ssh-rsa AAAA",
+			"hostnme":            "foo",
+			"ca_certs":           []interface{}{"-----BEGIN CERTIFICATE-----"},
+			"disks":              []interface{}{map[string]interface{}{"device": "/dev/sdb", "filesystem": "zfs"}},
+			"mounts":             []interface{}{map[string]interface{}{"device": "LABEL=data", "path": "data"}},
+			"users": []interface{}{
+				map[string]interface{}{"name": "alice", "gecos": "Alice:Admin", "hashed_password": "enc:AAAA"},
+			},
+			"write_files": []interface{}{
+				map[string]interface{}{
+					"path":        "/etc/foo",
+					"permissions": "0999",
+				},
+			},
+			"k3os": map[string]interface{}{
+				"server_url": "myserver:6443",
+				"taints":     []interface{}{"key1=value1:NoSchedule", "key2=value2"},
+				"token":      1234,
+				"time": map[string]interface{}{
+					"makestep": "1s",
+					"timezone": "Europe/Berlin",
+				},
+				"install": map[string]interface{}{
+					"silent": "yes",
+				},
+				"k3s": map[string]interface{}{
+					"node_ip":         "10.0.0.1",
+					"cluster_cidr":    "10.42.0.0",
+					"flannel_backend": "vxlan",
+				},
+				"network": map[string]interface{}{
+					"interfaces": []interface{}{
+						map[string]interface{}{
+							"mac":       "00:11:22:33:44",
+							"addresses": []interface{}{"10.0.0.2/24", "fd00::2"},
+							"routes":    []interface{}{map[string]interface{}{"to": "10.1.0.0/16", "via": "10.0.0.1"}},
+						},
+					},
+					"bonds": []interface{}{
+						map[string]interface{}{"name": "bond0", "mode": "lacp"},
+					},
+				},
+			},
+		}, nil
+	}})
+
+	expected := []string{
+		`test: ca_certs[0]: no PEM certificate found`,
+		`test: disks[0].filesystem: unsupported filesystem "zfs", must be one of ext2, ext3, ext4, xfs, btrfs, vfat`,
+		`test: hostnme: unknown key, did you mean "hostname"?`,
+		`test: k3os.install.silent: expected true or false, got "yes"`,
+		`test: k3os.k3s.cluster_cidr: "10.42.0.0" is not a CIDR such as 10.42.0.0/16`,
+		`test: k3os.network.bonds[0].mode: unknown bond mode "lacp", must be one of balance-rr, active-backup, balance-xor, broadcast, 802.3ad, balance-tlb, balance-alb`,
+		`test: k3os.network.interfaces[0].addresses[1]: "fd00::2" is not an address with a prefix length such as 10.0.0.2/24`,
+		`test: k3os.network.interfaces[0].mac: "00:11:22:33:44" is not a MAC address`,
+		`test: k3os.server_url: "myserver:6443" is not an http or https URL`,
+		`test: k3os.taints[1]: taint "key2=value2" must be in the form key[=value]:effect`,
+		`test: k3os.time.makestep: makestep "1s" must be a threshold in seconds and a limit such as "1.0 3"`,
+		`test: k3os.token: expected a string, got a number (quote the value)`,
+		`test: mounts[0].path: "data" is not an absolute path`,
+		`test: users[0].gecos: "Alice:Admin" must not contain a colon or a line break`,
+		`test: write_files[0].permissions: unable to parse file permissions "0999" as integer`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("got %d errors, expected %d: %v", len(errs), len(expected), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("got %q, expected %q", err.Error(), expected[i])
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	bytes, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"sshAuthorizedKeys"`, `"ssh_authorized_keys"`, `"dns_nameserver"`, `"#/definitions/k3OS"`} {
+		if !strings.Contains(string(bytes), key) {
+			t.Errorf("schema is missing %s", key)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 
@@ -27,8 +28,62 @@ func Write(cfg CloudConfig, writer io.Writer) error {
 	return err
 }
 
+// Dump reads the configuration like ReadConfig and writes it as YAML, or JSON if asJSON is set, keeping the
+// references to files and environment variables instead of their values. Secret values are redacted unless
+// showSecrets is set.
+func Dump(writer io.Writer, asJSON, showSecrets bool) error {
+	cfg, r, err := resolveSources(sources()...)
+	if err != nil {
+		return err
+	}
+
+	if !asJSON {
+		cfg.K3OS.Install = nil
+	}
+	cfg.APIVersion = APIVersion
+	data, err := convert.EncodeToMap(cfg)
+	if err != nil {
+		return err
+	}
+	r.unresolve(data)
+	if !showSecrets {
+		Redact(data)
+	}
+
+	if asJSON {
+		return json.NewEncoder(writer).Encode(data)
+	}
+
+	toYAMLKeys(data)
+	bytes, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(bytes)
+	return err
+}
+
+// WriteRedacted is like Write but with the values of secret fields redacted
+func WriteRedacted(cfg CloudConfig, writer io.Writer) error {
+	cfg.K3OS.Install = nil
+	data, err := convert.EncodeToMap(cfg)
+	if err != nil {
+		return err
+	}
+	Redact(data)
+
+	toYAMLKeys(data)
+	bytes, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(bytes)
+	return err
+}
+
 func ToBytes(cfg CloudConfig) ([]byte, error) {
 	cfg.K3OS.Install = nil
+	cfg.APIVersion = APIVersion
 	data, err := convert.EncodeToMap(cfg)
 	if err != nil {
 		return nil, err
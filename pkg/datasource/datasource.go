@@ -0,0 +1,269 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ConfigDir is where the data found by a provider is written for the config readers
+	ConfigDir = "/run/config"
+	// DefaultTimeout is how long each provider is given to find its data
+	DefaultTimeout = 10 * time.Second
+)
+
+// ErrNotFound is returned by a provider that is not available on this instance
+var ErrNotFound = errors.New("datasource not found")
+
+// Provider finds the configuration of this instance from one source of cloud metadata
+type Provider interface {
+	// Name of the provider, as used in `k3os.data_sources`
+	Name() string
+	// Fetch returns the data for this instance or ErrNotFound if the provider is not available
+	Fetch(ctx context.Context) (*Data, error)
+}
+
+// Data is everything a provider knows about this instance
+type Data struct {
+	Provider          string   `json:"provider"`
+	InstanceID        string   `json:"instanceId,omitempty"`
+	Hostname          string   `json:"hostname,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+	Network           *Network `json:"network,omitempty"`
+	UserData          []byte   `json:"-"`
+}
+
+// Network is the network configuration of the instance
+type Network struct {
+	Interfaces     []Interface `json:"interfaces,omitempty"`
+	DNSNameservers []string    `json:"dnsNameservers,omitempty"`
+}
+
+// Interface is a network interface of the instance, addresses are in CIDR notation
+type Interface struct {
+	Name      string   `json:"name,omitempty"`
+	MAC       string   `json:"mac,omitempty"`
+	DHCP      bool     `json:"dhcp,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Gateway   string   `json:"gateway,omitempty"`
+}
+
+// CloudConfig returns the configuration of the instance, the user-data is merged over the instance ID, hostname,
+// SSH keys and static network configuration from the instance metadata
+func (d *Data) CloudConfig() (config.CloudConfig, error) {
+	meta := map[string]interface{}{}
+	if d.InstanceID != "" {
+		meta["instanceId"] = d.InstanceID
+	}
+	if d.Hostname != "" {
+		meta["hostname"] = d.Hostname
+	}
+	if len(d.SSHAuthorizedKeys) > 0 {
+		meta["sshAuthorizedKeys"] = d.SSHAuthorizedKeys
+	}
+	k3os, err := d.Network.k3os()
+	if err != nil {
+		return config.CloudConfig{}, err
+	}
+	if len(k3os) > 0 {
+		meta["k3os"] = k3os
+	}
+
+	userData := map[string]interface{}{}
+	if len(d.UserData) > 0 {
+		if userData, err = config.ParseUserData(d.UserData); err != nil {
+			return config.CloudConfig{}, fmt.Errorf("failed to parse user-data from %s: %v", d.Provider, err)
+		}
+	}
+
+	return config.ToConfig(meta, userData)
+}
+
+// Config returns the static configuration of the interfaces as k3os.network, or nil if they all use DHCP.  The
+// addresses of an interface using DHCP are what DHCP assigns, so they are left to connman.
+func (n *Network) Config() *config.Network {
+	if n == nil {
+		return nil
+	}
+	result := &config.Network{}
+	for _, iface := range n.Interfaces {
+		if iface.DHCP || len(iface.Addresses) == 0 {
+			continue
+		}
+		c := config.NetworkInterface{
+			Name:      iface.Name,
+			MAC:       iface.MAC,
+			Addresses: iface.Addresses,
+		}
+		if ip := net.ParseIP(iface.Gateway); ip != nil && ip.To4() == nil {
+			c.Gateway6 = iface.Gateway
+		} else {
+			c.Gateway = iface.Gateway
+		}
+		if c.MAC != "" {
+			// interfaces are matched by MAC, the names of the provider are not the names of the kernel
+			c.Name = ""
+		}
+		result.Interfaces = append(result.Interfaces, c)
+	}
+	if len(result.Interfaces) == 0 {
+		return nil
+	}
+	return result
+}
+
+// k3os returns the k3os section of the configuration from the network metadata, in the form saved to network.json
+func (n *Network) k3os() (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	if n == nil {
+		return result, nil
+	}
+	if network := n.Config(); network != nil {
+		bytes, err := json.Marshal(network)
+		if err != nil {
+			return nil, err
+		}
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(bytes, &m); err != nil {
+			return nil, err
+		}
+		result["network"] = m
+	}
+	if len(n.DNSNameservers) > 0 {
+		var nameservers []interface{}
+		for _, ns := range n.DNSNameservers {
+			nameservers = append(nameservers, ns)
+		}
+		result["dnsNameservers"] = nameservers
+	}
+	return result, nil
+}
+
+// Get returns the providers for the `k3os.data_sources` names, in order
+func Get(names ...string) ([]Provider, error) {
+	var (
+		result   []Provider
+		external []string
+	)
+	for _, name := range names {
+		switch {
+		case name == "aws" || name == "ec2":
+			result = append(result, &EC2{})
+		case name == "gcp" || name == "gce":
+			result = append(result, &GCE{})
+		case name == "openstack":
+			result = append(result, &OpenStack{}, &ConfigDrive{})
+		case name == "config-drive" || name == "configdrive":
+			result = append(result, &ConfigDrive{})
+		case name == "cdrom" || name == "nocloud":
+			result = append(result, &NoCloud{})
+		case name == "vmware" || name == "guestinfo":
+			result = append(result, &VMware{})
+		case strings.HasPrefix(name, "url:"):
+			result = append(result, &URL{URL: strings.TrimPrefix(name, "url:")})
+		default:
+			external = append(external, name)
+		}
+	}
+	if len(external) > 0 {
+		if !util.ExistsAndExecutable(externalMetadata) {
+			return nil, fmt.Errorf("unsupported data sources: %s", strings.Join(external, ", "))
+		}
+		result = append(result, &External{Names: external})
+	}
+	return result, nil
+}
+
+// Fetch tries each provider in order and returns the data of the first one that is available. Each provider is
+// given timeout to respond.
+func Fetch(ctx context.Context, timeout time.Duration, providers ...Provider) (*Data, error) {
+	for _, p := range providers {
+		logrus.Debugf("trying data source %s", p.Name())
+		pctx, cancel := context.WithTimeout(ctx, timeout)
+		data, err := p.Fetch(pctx)
+		cancel()
+		if err == ErrNotFound {
+			logrus.Debugf("data source %s not found", p.Name())
+			continue
+		} else if err != nil {
+			logrus.Warnf("data source %s failed: %v", p.Name(), err)
+			continue
+		}
+		if len(data.UserData) > 0 {
+			// the includes are fetched once here, the configuration is read many times
+			if data.UserData, err = config.ResolveIncludes(data.UserData); err != nil {
+				logrus.Warnf("data source %s failed: %v", p.Name(), err)
+				continue
+			}
+		}
+		data.Provider = p.Name()
+		logrus.Infof("found data source %s", p.Name())
+		return data, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Write saves the data to dir as the files read by the config readers: userdata, local_hostname,
+// ssh/authorized_keys, instance_id and network.json, which holds the k3os.network and k3os.dns_nameservers of the
+// metadata
+func Write(data *Data, dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "ssh"), 0755); err != nil {
+		return err
+	}
+	if len(data.UserData) > 0 {
+		if err := util.WriteFileAtomic(filepath.Join(dir, "userdata"), data.UserData, 0600); err != nil {
+			return err
+		}
+	}
+	if data.Hostname != "" {
+		if err := util.WriteFileAtomic(filepath.Join(dir, "local_hostname"), []byte(data.Hostname+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	if len(data.SSHAuthorizedKeys) > 0 {
+		keys := strings.Join(data.SSHAuthorizedKeys, "\n") + "\n"
+		if err := util.WriteFileAtomic(filepath.Join(dir, "ssh", "authorized_keys"), []byte(keys), 0644); err != nil {
+			return err
+		}
+	}
+	if data.InstanceID != "" {
+		if err := util.WriteFileAtomic(filepath.Join(dir, "instance_id"), []byte(data.InstanceID+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	k3os, err := data.Network.k3os()
+	if err != nil {
+		return err
+	}
+	if len(k3os) > 0 {
+		bytes, err := json.MarshalIndent(k3os, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := util.WriteFileAtomic(filepath.Join(dir, "network.json"), bytes, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InstanceID returns the instance ID saved by Write to ConfigDir, if any
+func InstanceID() string {
+	bytes, err := ioutil.ReadFile(filepath.Join(ConfigDir, "instance_id"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes))
+}
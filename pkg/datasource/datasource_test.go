@@ -0,0 +1,342 @@
+package datasource
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+func serve(t *testing.T, headers map[string]string, files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range headers {
+			if r.Header.Get(k) != v {
+				t.Errorf("%s %s: missing header %s", r.Method, r.URL.Path, k)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		content, ok := files[r.Method+" "+r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		w.Write([]byte(content))
+	}))
+}
+
+func TestEC2(t *testing.T) {
+	server := serve(t, nil, map[string]string{
+		"PUT /latest/api/token":                           "TOKEN",
+		"GET /latest/meta-data/instance-id":               "i-1234",
+		"GET /latest/meta-data/local-hostname":            "ip-10-0-0-5",
+		"GET /latest/meta-data/public-keys/":              "0=my-key",
+		"GET /latest/meta-data/public-keys/0/openssh-key": "ssh-rsa AAAA my-key\n",
+		"GET /latest/meta-data/mac":                       "0a:00:00:00:00:01",
+		"GET /latest/meta-data/local-ipv4":                "10.0.0.5",
+		"GET /latest/meta-data/network/interfaces/macs/0a:00:00:00:00:01/subnet-ipv4-cidr-block": "10.0.0.0/24",
+		"GET /latest/user-data": "#cloud-config\nhostname: ec2\n",
+	})
+	defer server.Close()
+
+	data, err := (&EC2{BaseURL: server.URL}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Data{
+		InstanceID:        "i-1234",
+		Hostname:          "ip-10-0-0-5",
+		SSHAuthorizedKeys: []string{"ssh-rsa AAAA my-key"},
+		Network: &Network{Interfaces: []Interface{
+			{MAC: "0a:00:00:00:00:01", DHCP: true, Addresses: []string{"10.0.0.5/24"}},
+		}},
+		UserData: []byte("#cloud-config\nhostname: ec2\n"),
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Fatalf("got %+v, expected %+v", data, expected)
+	}
+
+	cfg, err := data.CloudConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Hostname != "ec2" || len(cfg.SSHAuthorizedKeys) != 1 {
+		t.Fatalf("user-data was not merged over the metadata: %+v", cfg)
+	}
+	// the address is assigned by DHCP, connman configures it
+	if cfg.InstanceID != "i-1234" || cfg.K3OS.Network != nil {
+		t.Errorf("unexpected instance ID %q or network %+v", cfg.InstanceID, cfg.K3OS.Network)
+	}
+}
+
+func TestGCE(t *testing.T) {
+	server := serve(t, gceHeaders, map[string]string{
+		"GET /instance/id":                                 "5555",
+		"GET /instance/hostname":                           "node.c.project.internal",
+		"GET /project/attributes/ssh-keys":                 "alice:ssh-rsa AAAA alice\nbob:ssh-ed25519 BBBB bob",
+		"GET /instance/network-interfaces/?recursive=true": `[{"mac":"42:01:0a:80:00:02","ip":"10.128.0.2","gateway":"10.128.0.1","subnetmask":"255.255.240.0"}]`,
+	})
+	defer server.Close()
+
+	data, err := (&GCE{BaseURL: server.URL}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.InstanceID != "5555" || data.Hostname != "node.c.project.internal" {
+		t.Errorf("unexpected data %+v", data)
+	}
+	if !reflect.DeepEqual(data.SSHAuthorizedKeys, []string{"ssh-rsa AAAA alice", "ssh-ed25519 BBBB bob"}) {
+		t.Errorf("unexpected keys %v", data.SSHAuthorizedKeys)
+	}
+	if data.Network == nil || data.Network.Interfaces[0].Addresses[0] != "10.128.0.2/20" {
+		t.Errorf("unexpected network %+v", data.Network)
+	}
+	if data.UserData != nil {
+		t.Errorf("unexpected user-data %q", data.UserData)
+	}
+	cfg, err := data.CloudConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InstanceID != "5555" || cfg.Hostname != "node.c.project.internal" || cfg.K3OS.Network != nil {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func TestOpenStack(t *testing.T) {
+	server := serve(t, nil, map[string]string{
+		"GET /meta_data.json":    `{"uuid":"abcd","hostname":"os-node","public_keys":{"mykey":"ssh-rsa AAAA"}}`,
+		"GET /network_data.json": `{"links":[{"id":"tap1","ethernet_mac_address":"fa:16:3e:00:00:01"}],"networks":[{"link":"tap1","type":"ipv4","ip_address":"192.168.0.10","netmask":"255.255.255.0","routes":[{"network":"0.0.0.0","gateway":"192.168.0.1"}]}],"services":[{"type":"dns","address":"1.1.1.1"}]}`,
+		"GET /user_data":         "#!/bin/sh\necho hi\n",
+	})
+	defer server.Close()
+
+	data, err := (&OpenStack{BaseURL: server.URL}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Network{
+		Interfaces: []Interface{
+			{MAC: "fa:16:3e:00:00:01", Addresses: []string{"192.168.0.10/24"}, Gateway: "192.168.0.1"},
+		},
+		DNSNameservers: []string{"1.1.1.1"},
+	}
+	if data.InstanceID != "abcd" || !reflect.DeepEqual(data.Network, expected) {
+		t.Errorf("unexpected data %+v %+v", data, data.Network)
+	}
+	cfg, err := data.CloudConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Runcmd) != 1 || len(cfg.WriteFiles) != 1 {
+		t.Errorf("user-data script was not converted: %+v", cfg)
+	}
+	network := &config.Network{Interfaces: []config.NetworkInterface{
+		{MAC: "fa:16:3e:00:00:01", Addresses: []string{"192.168.0.10/24"}, Gateway: "192.168.0.1"},
+	}}
+	if cfg.InstanceID != "abcd" || !reflect.DeepEqual(cfg.K3OS.Network, network) ||
+		!reflect.DeepEqual(cfg.K3OS.DNSNameservers, []string{"1.1.1.1"}) {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func TestNoCloud(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nocloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"meta-data": "instance-id: iid-local01\nlocal-hostname: cloudimg\npublic-keys:\n- ssh-rsa AAAA\n",
+		"user-data": "#cloud-config\nk3os:\n  token: secret\n",
+		"network-config": `version: 2
+ethernets:
+  id0:
+    match:
+      macaddress: "52:54:00:12:34:00"
+    addresses: [192.168.1.10/24]
+    gateway4: 192.168.1.1
+    nameservers:
+      addresses: [8.8.8.8]
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := (&NoCloud{Dir: dir}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Data{
+		InstanceID:        "iid-local01",
+		Hostname:          "cloudimg",
+		SSHAuthorizedKeys: []string{"ssh-rsa AAAA"},
+		Network: &Network{
+			Interfaces: []Interface{
+				{MAC: "52:54:00:12:34:00", Addresses: []string{"192.168.1.10/24"}, Gateway: "192.168.1.1"},
+			},
+			DNSNameservers: []string{"8.8.8.8"},
+		},
+		UserData: []byte(files["user-data"]),
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Fatalf("got %+v, expected %+v", data, expected)
+	}
+
+	out, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	if err := Write(data, out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"userdata", "local_hostname", "ssh/authorized_keys", "instance_id", "network.json"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cfg, err := data.CloudConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	network := &config.Network{Interfaces: []config.NetworkInterface{
+		{MAC: "52:54:00:12:34:00", Addresses: []string{"192.168.1.10/24"}, Gateway: "192.168.1.1"},
+	}}
+	if cfg.InstanceID != "iid-local01" || !reflect.DeepEqual(cfg.K3OS.Network, network) ||
+		!reflect.DeepEqual(cfg.K3OS.DNSNameservers, []string{"8.8.8.8"}) || cfg.K3OS.Token != "secret" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	// network.json is read back as the k3os section of the configuration
+	saved, err := ioutil.ReadFile(filepath.Join(out, "network.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k3os := map[string]interface{}{}
+	if err := json.Unmarshal(saved, &k3os); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = config.ToConfig(map[string]interface{}{"k3os": k3os})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.K3OS.Network, network) || !reflect.DeepEqual(cfg.K3OS.DNSNameservers, []string{"8.8.8.8"}) {
+		t.Errorf("unexpected network.json:\n%s", saved)
+	}
+}
+
+func TestVMware(t *testing.T) {
+	vars := map[string]string{
+		"metadata":          base64.StdEncoding.EncodeToString([]byte("instance-id: vm-1\nlocal-hostname: vm\n")),
+		"metadata.encoding": "base64",
+		"userdata":          "#cloud-config\nhostname: fromuserdata\n",
+	}
+	v := &VMware{RPC: func(ctx context.Context, key string) (string, error) {
+		return vars[key], nil
+	}}
+	data, err := v.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.InstanceID != "vm-1" || data.Hostname != "vm" || string(data.UserData) != vars["userdata"] {
+		t.Errorf("unexpected data %+v", data)
+	}
+	cfg, err := data.CloudConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InstanceID != "vm-1" || cfg.Hostname != "fromuserdata" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func TestFetchFallback(t *testing.T) {
+	server := serve(t, nil, map[string]string{
+		"GET /user-data": "hostname: fromurl\n",
+	})
+	defer server.Close()
+
+	// nothing listens on the EC2 server after it is closed
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	data, err := Fetch(context.Background(), 2*time.Second,
+		&EC2{BaseURL: closed.URL},
+		&URL{URL: server.URL + "/missing"},
+		&URL{URL: server.URL + "/user-data"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Provider != "url:"+server.URL+"/user-data" {
+		t.Errorf("got provider %s", data.Provider)
+	}
+}
+
+func TestURLProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.String() != "http://user-data.example/user-data" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hostname: fromproxy\n"))
+	}))
+	defer proxy.Close()
+	defer util.ResetProxy()
+	util.SetProxy(util.Proxy{HTTP: proxy.URL})
+
+	data, err := (&URL{URL: "http://user-data.example/user-data"}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data.UserData) != "hostname: fromproxy\n" {
+		t.Errorf("got user-data %q", data.UserData)
+	}
+}
+
+func TestFetchInclude(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user-data":
+			fmt.Fprintf(w, "#include\n%s/included\n", server.URL)
+		case "/included":
+			w.Write([]byte("hostname: included\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	data, err := Fetch(context.Background(), 2*time.Second, &URL{URL: server.URL + "/user-data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	cfg, err := data.CloudConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Hostname != "included" {
+		t.Errorf("got hostname %q, expected the included one", cfg.Hostname)
+	}
+}
@@ -0,0 +1,131 @@
+package datasource
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const ec2TokenTTL = "21600"
+
+// EC2 reads the AWS instance metadata service using IMDSv2 session tokens, falling back to IMDSv1 when tokens are
+// not supported
+type EC2 struct {
+	// BaseURL of the metadata service, defaults to http://169.254.169.254
+	BaseURL string
+
+	token string
+}
+
+func (e *EC2) Name() string {
+	return "aws"
+}
+
+func (e *EC2) Fetch(ctx context.Context) (*Data, error) {
+	base := e.BaseURL
+	if base == "" {
+		base = "http://169.254.169.254"
+	}
+
+	token, _, err := request(ctx, http.MethodPut, base+"/latest/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": ec2TokenTTL,
+	})
+	switch {
+	case err == nil:
+		e.token = string(token)
+	case err == ErrNotFound && ctx.Err() != nil:
+		// the metadata service is not reachable
+		return nil, err
+	case err == ErrNotFound, isStatus(err, http.StatusForbidden, http.StatusMethodNotAllowed):
+		// no IMDSv2 support, use IMDSv1
+		e.token = ""
+	default:
+		return nil, err
+	}
+
+	data := &Data{}
+	instanceID, err := e.get(ctx, base+"/latest/meta-data/instance-id")
+	if err != nil {
+		return nil, err
+	}
+	data.InstanceID = string(instanceID)
+
+	hostname, err := optional(e.get(ctx, base+"/latest/meta-data/local-hostname"))
+	if err != nil {
+		return nil, err
+	}
+	data.Hostname = strings.TrimSpace(string(hostname))
+
+	keys, err := optional(e.get(ctx, base+"/latest/meta-data/public-keys/"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(keys), "\n") {
+		// each line is index=name
+		index := strings.SplitN(strings.TrimSpace(line), "=", 2)[0]
+		if index == "" {
+			continue
+		}
+		key, err := e.get(ctx, base+"/latest/meta-data/public-keys/"+index+"/openssh-key")
+		if err != nil {
+			return nil, err
+		}
+		data.SSHAuthorizedKeys = append(data.SSHAuthorizedKeys, strings.TrimSpace(string(key)))
+	}
+
+	if data.Network, err = e.network(ctx, base); err != nil {
+		return nil, err
+	}
+
+	if data.UserData, err = optional(e.get(ctx, base+"/latest/user-data")); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (e *EC2) network(ctx context.Context, base string) (*Network, error) {
+	mac, err := optional(e.get(ctx, base+"/latest/meta-data/mac"))
+	if err != nil || len(mac) == 0 {
+		return nil, err
+	}
+	iface := Interface{
+		MAC:  strings.TrimSpace(string(mac)),
+		DHCP: true,
+	}
+
+	ip, err := optional(e.get(ctx, base+"/latest/meta-data/local-ipv4"))
+	if err != nil {
+		return nil, err
+	}
+	cidr, err := optional(e.get(ctx, base+"/latest/meta-data/network/interfaces/macs/"+iface.MAC+"/subnet-ipv4-cidr-block"))
+	if err != nil {
+		return nil, err
+	}
+	if addr := toCIDR(strings.TrimSpace(string(ip)), strings.TrimSpace(string(cidr))); addr != "" {
+		iface.Addresses = append(iface.Addresses, addr)
+	}
+
+	return &Network{Interfaces: []Interface{iface}}, nil
+}
+
+func (e *EC2) get(ctx context.Context, url string) ([]byte, error) {
+	var headers map[string]string
+	if e.token != "" {
+		headers = map[string]string{"X-aws-ec2-metadata-token": e.token}
+	}
+	return get(ctx, url, headers)
+}
+
+// toCIDR combines an address with the prefix length of the subnet it is in
+func toCIDR(ip, subnet string) string {
+	if ip == "" {
+		return ""
+	}
+	if _, network, err := net.ParseCIDR(subnet); err == nil {
+		ones, _ := network.Mask.Size()
+		return (&net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(ones, len(network.Mask)*8)}).String()
+	}
+	return ip
+}
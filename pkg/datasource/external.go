@@ -0,0 +1,53 @@
+package datasource
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const externalMetadata = "/usr/sbin/metadata"
+
+// External runs the linuxkit metadata binary for the data sources without a native provider
+type External struct {
+	Names []string
+}
+
+func (e *External) Name() string {
+	return strings.Join(e.Names, ",")
+}
+
+func (e *External) Fetch(ctx context.Context) (*Data, error) {
+	cmd := exec.CommandContext(ctx, externalMetadata, e.Names...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	// the binary writes the same files as Write, read them back
+	read := dirReader(ConfigDir)
+	data := &Data{}
+	userData, err := optional(read("userdata"))
+	if err != nil {
+		return nil, err
+	}
+	data.UserData = userData
+	if hostname, err := ioutil.ReadFile(filepath.Join(ConfigDir, "local_hostname")); err == nil {
+		data.Hostname = strings.TrimSpace(string(hostname))
+	}
+	if keys, err := ioutil.ReadFile(filepath.Join(ConfigDir, "ssh", "authorized_keys")); err == nil {
+		for _, key := range strings.Split(string(keys), "\n") {
+			if key = strings.TrimSpace(key); key != "" {
+				data.SSHAuthorizedKeys = append(data.SSHAuthorizedKeys, key)
+			}
+		}
+	}
+	if data.UserData == nil && data.Hostname == "" && len(data.SSHAuthorizedKeys) == 0 {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
@@ -0,0 +1,71 @@
+package datasource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/sirupsen/logrus"
+)
+
+// filesystems tried, in order, when mounting a seed image
+var filesystems = []string{"iso9660", "vfat", "ext4"}
+
+// findLabel returns the block device with one of the filesystem labels
+func findLabel(labels ...string) (string, error) {
+	for _, label := range labels {
+		if dev, err := filepath.EvalSymlinks(filepath.Join("/dev/disk/by-label", label)); err == nil {
+			return dev, nil
+		}
+		if out, err := exec.Command("blkid", "-L", label).Output(); err == nil {
+			if dev := strings.TrimSpace(string(out)); dev != "" {
+				return dev, nil
+			}
+		}
+	}
+	return "", ErrNotFound
+}
+
+// mountLabel mounts the filesystem with one of the labels read-only on a temporary directory. The returned func
+// unmounts it again.
+func mountLabel(labels ...string) (string, func(), error) {
+	dev, err := findLabel(labels...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "k3os-datasource")
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, fs := range filesystems {
+		if err = mount.Mount(dev, dir, fs, "ro"); err == nil {
+			logrus.Debugf("mounted %s (%s) on %s", dev, fs, dir)
+			return dir, func() {
+				if err := mount.Unmount(dir); err != nil {
+					logrus.Warnf("failed to unmount %s: %v", dir, err)
+				}
+				os.Remove(dir)
+			}, nil
+		}
+	}
+
+	os.Remove(dir)
+	return "", nil, fmt.Errorf("failed to mount %s: %v", dev, err)
+}
+
+// dirReader reads files relative to a directory, missing files return ErrNotFound
+func dirReader(dir string) func(string) ([]byte, error) {
+	return func(path string) ([]byte, error) {
+		bytes, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return bytes, err
+	}
+}
@@ -0,0 +1,115 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var gceHeaders = map[string]string{"Metadata-Flavor": "Google"}
+
+// GCE reads the Google Compute Engine metadata server
+type GCE struct {
+	// BaseURL of the metadata server, defaults to http://169.254.169.254/computeMetadata/v1
+	BaseURL string
+}
+
+func (g *GCE) Name() string {
+	return "gcp"
+}
+
+func (g *GCE) Fetch(ctx context.Context) (*Data, error) {
+	base := g.BaseURL
+	if base == "" {
+		base = "http://169.254.169.254/computeMetadata/v1"
+	}
+
+	instanceID, header, err := request(ctx, http.MethodGet, base+"/instance/id", gceHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if header.Get("Metadata-Flavor") != "Google" {
+		return nil, ErrNotFound
+	}
+
+	data := &Data{
+		InstanceID: string(instanceID),
+	}
+
+	hostname, err := optional(get(ctx, base+"/instance/hostname", gceHeaders))
+	if err != nil {
+		return nil, err
+	}
+	data.Hostname = string(hostname)
+
+	for _, path := range []string{"/project/attributes/ssh-keys", "/instance/attributes/ssh-keys"} {
+		keys, err := optional(get(ctx, base+path, gceHeaders))
+		if err != nil {
+			return nil, err
+		}
+		data.SSHAuthorizedKeys = append(data.SSHAuthorizedKeys, gceKeys(string(keys))...)
+	}
+
+	interfaces, err := optional(get(ctx, base+"/instance/network-interfaces/?recursive=true", gceHeaders))
+	if err != nil {
+		return nil, err
+	}
+	if len(interfaces) > 0 {
+		if data.Network, err = gceNetwork(interfaces); err != nil {
+			return nil, err
+		}
+	}
+
+	if data.UserData, err = optional(get(ctx, base+"/instance/attributes/user-data", gceHeaders)); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// gceKeys parses the ssh-keys attribute, each line is user:key
+func gceKeys(keys string) []string {
+	var result []string
+	for _, line := range strings.Split(keys, "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, ":"); i > 0 && !strings.Contains(line[:i], " ") {
+			line = line[i+1:]
+		}
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func gceNetwork(data []byte) (*Network, error) {
+	var interfaces []struct {
+		MAC        string `json:"mac"`
+		IP         string `json:"ip"`
+		Gateway    string `json:"gateway"`
+		SubnetMask string `json:"subnetmask"`
+	}
+	if err := json.Unmarshal(data, &interfaces); err != nil {
+		return nil, err
+	}
+
+	network := &Network{}
+	for _, i := range interfaces {
+		iface := Interface{
+			MAC:     i.MAC,
+			DHCP:    true,
+			Gateway: i.Gateway,
+		}
+		if ip := net.ParseIP(i.IP); ip != nil {
+			mask := net.IPMask(net.ParseIP(i.SubnetMask).To4())
+			if len(mask) == 0 {
+				mask = net.CIDRMask(32, 32)
+			}
+			iface.Addresses = append(iface.Addresses, (&net.IPNet{IP: ip, Mask: mask}).String())
+		}
+		network.Interfaces = append(network.Interfaces, iface)
+	}
+	return network, nil
+}
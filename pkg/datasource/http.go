@@ -0,0 +1,107 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// retryInterval is how long to wait before retrying a request that failed to connect, the network can be up
+// before the metadata service is reachable
+const retryInterval = time.Second
+
+// client never uses a proxy, the proxy of k3os.proxy is in the environment but the metadata services are only
+// reachable directly.  The url provider fetches from anywhere and uses util.HTTPClient instead.
+var client = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 nil,
+		DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+}
+
+// httpStatusError is returned for a response that is not 200 OK
+type httpStatusError struct {
+	url        string
+	statusCode int
+}
+
+func (e httpStatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d", e.url, e.statusCode)
+}
+
+func isStatus(err error, codes ...int) bool {
+	if e, ok := err.(httpStatusError); ok {
+		for _, code := range codes {
+			if e.statusCode == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// request performs an HTTP request, retrying connection errors until the context is done. A 404 response or no
+// response at all returns ErrNotFound, any other status besides 200 returns an httpStatusError.
+func request(ctx context.Context, method, url string, headers map[string]string) ([]byte, http.Header, error) {
+	return requestWith(ctx, client, method, url, headers)
+}
+
+// requestWith is request through the client c
+func requestWith(ctx context.Context, c *http.Client, method, url string, headers map[string]string) ([]byte,
+	http.Header, error) {
+	for {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		req = req.WithContext(ctx)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := c.Do(req)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				// the metadata service never answered, most likely this is not the right cloud
+				logrus.Debugf("%s %s: %v", method, url, err)
+				return nil, nil, ErrNotFound
+			case <-time.After(retryInterval):
+				continue
+			}
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return body, resp.Header, nil
+		case http.StatusNotFound:
+			return nil, resp.Header, ErrNotFound
+		}
+		return nil, resp.Header, httpStatusError{url: url, statusCode: resp.StatusCode}
+	}
+}
+
+func get(ctx context.Context, url string, headers map[string]string) ([]byte, error) {
+	body, _, err := request(ctx, http.MethodGet, url, headers)
+	return body, err
+}
+
+// optional turns ErrNotFound into empty data, for metadata that is not always present
+func optional(data []byte, err error) ([]byte, error) {
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return data, err
+}
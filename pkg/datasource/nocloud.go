@@ -0,0 +1,216 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/rancher/mapper/convert"
+)
+
+// NoCloud reads a cloud-init NoCloud seed, the filesystem labeled cidata containing meta-data, user-data and
+// optionally network-config
+type NoCloud struct {
+	// Dir is the root of an already mounted seed, if empty the seed is found by label and mounted
+	Dir string
+}
+
+func (n *NoCloud) Name() string {
+	return "nocloud"
+}
+
+func (n *NoCloud) Fetch(ctx context.Context) (*Data, error) {
+	dir := n.Dir
+	if dir == "" {
+		mounted, unmount, err := mountLabel("cidata", "CIDATA")
+		if err != nil {
+			return nil, err
+		}
+		defer unmount()
+		dir = mounted
+	}
+	read := dirReader(dir)
+
+	meta, err := read("meta-data")
+	if err != nil {
+		return nil, err
+	}
+	data, err := parseMetaData(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	network, err := optional(read("network-config"))
+	if err != nil {
+		return nil, err
+	}
+	if len(network) > 0 {
+		if data.Network, err = parseNetworkConfig(network); err != nil {
+			return nil, err
+		}
+	}
+
+	if data.UserData, err = optional(read("user-data")); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// parseMetaData parses cloud-init style meta-data, as used by NoCloud and VMware guestinfo
+func parseMetaData(bytes []byte) (*Data, error) {
+	meta := map[string]interface{}{}
+	if err := yaml.Unmarshal(bytes, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse meta-data: %v", err)
+	}
+
+	data := &Data{
+		InstanceID: convert.ToString(meta["instance-id"]),
+		Hostname:   convert.ToString(meta["local-hostname"]),
+	}
+	if data.Hostname == "" {
+		data.Hostname = convert.ToString(meta["hostname"])
+	}
+
+	switch keys := meta["public-keys"].(type) {
+	case string:
+		data.SSHAuthorizedKeys = append(data.SSHAuthorizedKeys, keys)
+	case []interface{}:
+		for _, key := range keys {
+			data.SSHAuthorizedKeys = append(data.SSHAuthorizedKeys, convert.ToString(key))
+		}
+	case map[string]interface{}:
+		var names []string
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			data.SSHAuthorizedKeys = append(data.SSHAuthorizedKeys, convert.ToString(keys[name]))
+		}
+	}
+
+	return data, nil
+}
+
+// parseNetworkConfig parses the ethernets of a version 2 network-config, or the physical interfaces of a
+// version 1 network-config
+func parseNetworkConfig(bytes []byte) (*Network, error) {
+	var cfg struct {
+		Network *struct {
+			Version   int                      `json:"version"`
+			Ethernets map[string]netplanDevice `json:"ethernets"`
+			Config    []networkV1Config        `json:"config"`
+		} `json:"network"`
+		Version   int                      `json:"version"`
+		Ethernets map[string]netplanDevice `json:"ethernets"`
+		Config    []networkV1Config        `json:"config"`
+	}
+	if err := yaml.Unmarshal(bytes, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse network-config: %v", err)
+	}
+	// the network key is optional
+	if cfg.Network != nil {
+		cfg.Version, cfg.Ethernets, cfg.Config = cfg.Network.Version, cfg.Network.Ethernets, cfg.Network.Config
+	}
+
+	network := &Network{}
+	switch cfg.Version {
+	case 1:
+		for _, c := range cfg.Config {
+			switch c.Type {
+			case "physical":
+				iface := Interface{Name: c.Name, MAC: c.MACAddress}
+				for _, s := range c.Subnets {
+					switch s.Type {
+					case "dhcp", "dhcp4", "dhcp6":
+						iface.DHCP = true
+					case "static", "static6":
+						iface.Addresses = append(iface.Addresses, withNetmask(s.Address, s.Netmask))
+						if s.Gateway != "" {
+							iface.Gateway = s.Gateway
+						}
+						network.DNSNameservers = append(network.DNSNameservers, s.DNSNameservers...)
+					}
+				}
+				network.Interfaces = append(network.Interfaces, iface)
+			case "nameserver":
+				network.DNSNameservers = append(network.DNSNameservers, c.Address...)
+			}
+		}
+	case 2:
+		var names []string
+		for name := range cfg.Ethernets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			dev := cfg.Ethernets[name]
+			iface := Interface{
+				Name:      name,
+				MAC:       dev.Match.MACAddress,
+				DHCP:      dev.DHCP4 || dev.DHCP6,
+				Addresses: dev.Addresses,
+				Gateway:   dev.Gateway4,
+			}
+			if dev.Match.MACAddress != "" && dev.Match.Name == "" {
+				// the key is only an identifier when matching by MAC
+				iface.Name = ""
+			}
+			if iface.Gateway == "" {
+				iface.Gateway = dev.Gateway6
+			}
+			network.Interfaces = append(network.Interfaces, iface)
+			network.DNSNameservers = append(network.DNSNameservers, dev.Nameservers.Addresses...)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported network-config version %d", cfg.Version)
+	}
+	return network, nil
+}
+
+type netplanDevice struct {
+	Match struct {
+		Name       string `json:"name"`
+		MACAddress string `json:"macaddress"`
+	} `json:"match"`
+	DHCP4       bool     `json:"dhcp4"`
+	DHCP6       bool     `json:"dhcp6"`
+	Addresses   []string `json:"addresses"`
+	Gateway4    string   `json:"gateway4"`
+	Gateway6    string   `json:"gateway6"`
+	Nameservers struct {
+		Addresses []string `json:"addresses"`
+	} `json:"nameservers"`
+}
+
+type networkV1Config struct {
+	Type       string   `json:"type"`
+	Name       string   `json:"name"`
+	MACAddress string   `json:"mac_address"`
+	Address    []string `json:"address"`
+	Subnets    []struct {
+		Type           string   `json:"type"`
+		Address        string   `json:"address"`
+		Netmask        string   `json:"netmask"`
+		Gateway        string   `json:"gateway"`
+		DNSNameservers []string `json:"dns_nameservers"`
+	} `json:"subnets"`
+}
+
+func withNetmask(address, netmask string) string {
+	if strings.Contains(address, "/") || netmask == "" {
+		return address
+	}
+	ip, mask := net.ParseIP(address), net.ParseIP(netmask)
+	if ip == nil || mask == nil {
+		return address
+	}
+	if ip.To4() != nil {
+		mask = mask.To4()
+	}
+	return (&net.IPNet{IP: ip, Mask: net.IPMask(mask)}).String()
+}
@@ -0,0 +1,158 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sort"
+)
+
+// OpenStack reads the OpenStack metadata service
+type OpenStack struct {
+	// BaseURL of the metadata service, defaults to http://169.254.169.254/openstack/latest
+	BaseURL string
+}
+
+func (o *OpenStack) Name() string {
+	return "openstack"
+}
+
+func (o *OpenStack) Fetch(ctx context.Context) (*Data, error) {
+	base := o.BaseURL
+	if base == "" {
+		base = "http://169.254.169.254/openstack/latest"
+	}
+	return openstackData(func(path string) ([]byte, error) {
+		return get(ctx, base+"/"+path, nil)
+	})
+}
+
+// ConfigDrive reads an OpenStack config drive, the filesystem labeled config-2
+type ConfigDrive struct {
+	// Dir is the root of an already mounted config drive, if empty the drive is found by label and mounted
+	Dir string
+}
+
+func (c *ConfigDrive) Name() string {
+	return "config-drive"
+}
+
+func (c *ConfigDrive) Fetch(ctx context.Context) (*Data, error) {
+	dir := c.Dir
+	if dir == "" {
+		mounted, unmount, err := mountLabel("config-2", "CONFIG-2")
+		if err != nil {
+			return nil, err
+		}
+		defer unmount()
+		dir = mounted
+	}
+	return openstackData(dirReader(dir + "/openstack/latest"))
+}
+
+func openstackData(read func(string) ([]byte, error)) (*Data, error) {
+	bytes, err := read("meta_data.json")
+	if err != nil {
+		return nil, err
+	}
+	var meta struct {
+		UUID       string            `json:"uuid"`
+		Hostname   string            `json:"hostname"`
+		PublicKeys map[string]string `json:"public_keys"`
+	}
+	if err := json.Unmarshal(bytes, &meta); err != nil {
+		return nil, err
+	}
+
+	data := &Data{
+		InstanceID: meta.UUID,
+		Hostname:   meta.Hostname,
+	}
+	var names []string
+	for name := range meta.PublicKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data.SSHAuthorizedKeys = append(data.SSHAuthorizedKeys, meta.PublicKeys[name])
+	}
+
+	network, err := optional(read("network_data.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(network) > 0 {
+		if data.Network, err = openstackNetwork(network); err != nil {
+			return nil, err
+		}
+	}
+
+	if data.UserData, err = optional(read("user_data")); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func openstackNetwork(bytes []byte) (*Network, error) {
+	var networkData struct {
+		Links []struct {
+			ID  string `json:"id"`
+			MAC string `json:"ethernet_mac_address"`
+		} `json:"links"`
+		Networks []struct {
+			Link      string `json:"link"`
+			Type      string `json:"type"`
+			IPAddress string `json:"ip_address"`
+			Netmask   string `json:"netmask"`
+			Routes    []struct {
+				Network string `json:"network"`
+				Gateway string `json:"gateway"`
+			} `json:"routes"`
+		} `json:"networks"`
+		Services []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"services"`
+	}
+	if err := json.Unmarshal(bytes, &networkData); err != nil {
+		return nil, err
+	}
+
+	network := &Network{}
+	for _, link := range networkData.Links {
+		iface := Interface{MAC: link.MAC}
+		for _, n := range networkData.Networks {
+			if n.Link != link.ID {
+				continue
+			}
+			switch n.Type {
+			case "ipv4_dhcp", "ipv6_dhcp", "ipv6_slaac":
+				iface.DHCP = true
+			case "ipv4", "ipv6":
+				addr := n.IPAddress
+				if ip := net.ParseIP(n.IPAddress); ip != nil && n.Netmask != "" {
+					if mask := net.ParseIP(n.Netmask); mask != nil {
+						if ip.To4() != nil {
+							mask = mask.To4()
+						}
+						addr = (&net.IPNet{IP: ip, Mask: net.IPMask(mask)}).String()
+					}
+				}
+				iface.Addresses = append(iface.Addresses, addr)
+				for _, r := range n.Routes {
+					if r.Network == "0.0.0.0" || r.Network == "::" {
+						iface.Gateway = r.Gateway
+					}
+				}
+			}
+		}
+		network.Interfaces = append(network.Interfaces, iface)
+	}
+	for _, s := range networkData.Services {
+		if s.Type == "dns" {
+			network.DNSNameservers = append(network.DNSNameservers, s.Address)
+		}
+	}
+	return network, nil
+}
@@ -0,0 +1,26 @@
+package datasource
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rancher/k3os/pkg/util"
+)
+
+// URL reads user-data from a plain HTTP(S) URL, configured as `url:<URL>`, through the proxy and CA certificates of
+// the other downloads of k3os
+type URL struct {
+	URL string
+}
+
+func (u *URL) Name() string {
+	return "url:" + u.URL
+}
+
+func (u *URL) Fetch(ctx context.Context) (*Data, error) {
+	userData, _, err := requestWith(ctx, util.HTTPClient(), http.MethodGet, u.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Data{UserData: userData}, nil
+}
@@ -0,0 +1,100 @@
+package datasource
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/rancher/k3os/pkg/util"
+)
+
+// VMware reads the guestinfo variables of a VMware virtual machine: guestinfo.metadata, guestinfo.userdata and
+// their .encoding variables
+type VMware struct {
+	// RPC returns the value of a guestinfo variable, by default vmware-rpctool is used
+	RPC func(ctx context.Context, key string) (string, error)
+}
+
+func (v *VMware) Name() string {
+	return "vmware"
+}
+
+func (v *VMware) Fetch(ctx context.Context) (*Data, error) {
+	rpc := v.RPC
+	if rpc == nil {
+		if _, err := exec.LookPath("vmware-rpctool"); err != nil {
+			return nil, ErrNotFound
+		}
+		rpc = rpcTool
+	}
+
+	meta, err := guestinfo(ctx, rpc, "metadata")
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) == 0 {
+		return nil, ErrNotFound
+	}
+	data, err := parseMetaData(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// the network config is embedded in the metadata
+	var network struct {
+		Network         interface{} `json:"network"`
+		NetworkEncoding string      `json:"network.encoding"`
+	}
+	if err := yaml.Unmarshal(meta, &network); err != nil {
+		return nil, err
+	}
+	if n, ok := network.Network.(string); ok && n != "" {
+		bytes, err := util.DecodeContent(n, network.NetworkEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if data.Network, err = parseNetworkConfig(bytes); err != nil {
+			return nil, err
+		}
+	} else if network.Network != nil {
+		bytes, err := yaml.Marshal(map[string]interface{}{"network": network.Network})
+		if err != nil {
+			return nil, err
+		}
+		if data.Network, err = parseNetworkConfig(bytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if data.UserData, err = guestinfo(ctx, rpc, "userdata"); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// guestinfo returns the decoded value of a guestinfo variable, or nothing if it is not set
+func guestinfo(ctx context.Context, rpc func(context.Context, string) (string, error), key string) ([]byte, error) {
+	value, err := rpc(ctx, key)
+	if err != nil || value == "" {
+		return nil, err
+	}
+	encoding, err := rpc(ctx, key+".encoding")
+	if err != nil {
+		return nil, err
+	}
+	return util.DecodeContent(value, strings.TrimSpace(encoding))
+}
+
+func rpcTool(ctx context.Context, key string) (string, error) {
+	out, err := exec.CommandContext(ctx, "vmware-rpctool", "info-get guestinfo."+key).Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// the variable is not set
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -2,44 +2,49 @@ package hostname
 
 import (
 	"bufio"
-	"io/ioutil"
+	"bytes"
 	"os"
 	"strings"
 	"syscall"
 
 	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
 )
 
-func SetHostname(c *config.CloudConfig) error {
+func SetHostname(t *target.Target, c *config.CloudConfig) error {
 	hostname := c.Hostname
 	if hostname == "" {
 		return nil
 	}
-	if err := syscall.Sethostname([]byte(hostname)); err != nil {
+	if err := t.Kernel("set hostname to "+hostname, func() error {
+		return syscall.Sethostname([]byte(hostname))
+	}); err != nil {
 		return err
 	}
-	return syncHostname()
+	if t.IsLive() && !t.DryRun {
+		// the kernel may have shortened it
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	return syncHostname(t, hostname)
 }
 
-func syncHostname() error {
-	hostname, err := os.Hostname()
-	if err != nil {
-		return err
-	}
+func syncHostname(t *target.Target, hostname string) error {
 	if hostname == "" {
 		return nil
 	}
 
-	if err := ioutil.WriteFile("/etc/hostname", []byte(hostname+"\n"), 0644); err != nil {
+	if err := t.WriteFile("/etc/hostname", []byte(hostname+"\n"), 0644); err != nil {
 		return err
 	}
 
-	hosts, err := os.Open("/etc/hosts")
-	defer hosts.Close()
+	hosts, err := t.ReadFile("/etc/hosts")
 	if err != nil {
 		return err
 	}
-	lines := bufio.NewScanner(hosts)
+	lines := bufio.NewScanner(bytes.NewReader(hosts))
 	content := ""
 	for lines.Scan() {
 		line := strings.TrimSpace(lines.Text())
@@ -50,5 +55,5 @@ func syncHostname() error {
 		}
 		content += line + "\n"
 	}
-	return ioutil.WriteFile("/etc/hosts", []byte(content), 0600)
+	return t.WriteFile("/etc/hosts", []byte(content), 0600)
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/paultag/go-modprobe"
 	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,7 +16,10 @@ const (
 	procModulesFile = "/proc/modules"
 )
 
-func LoadModules(cfg *config.CloudConfig) error {
+func LoadModules(t *target.Target, cfg *config.CloudConfig) error {
+	if len(cfg.K3OS.Modules) == 0 || !t.IsLive() && !t.DryRun {
+		return nil
+	}
 	loaded := map[string]bool{}
 	f, err := os.Open(procModulesFile)
 	if err != nil {
@@ -33,7 +37,9 @@ func LoadModules(cfg *config.CloudConfig) error {
 		}
 		params := strings.SplitN(m, " ", -1)
 		logrus.Debugf("module %s with parameters [%s] is loading", m, params)
-		if err := modprobe.Load(params[0], strings.Join(params[1:], " ")); err != nil {
+		if err := t.Kernel("load module "+m, func() error {
+			return modprobe.Load(params[0], strings.Join(params[1:], " "))
+		}); err != nil {
 			return fmt.Errorf("could not load module %s with parameters [%s], err %v", m, params, err)
 		}
 		logrus.Debugf("module %s is loaded", m)
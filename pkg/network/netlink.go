@@ -0,0 +1,251 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// the attributes of the link kinds that are not in x/sys/unix
+const (
+	iflaVLANID   = 1
+	iflaBondMode = 1
+)
+
+var native = nativeEndian()
+
+func nativeEndian() binary.ByteOrder {
+	i := uint16(1)
+	if *(*byte)(unsafe.Pointer(&i)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// attr is a netlink route attribute, its data is either raw or the nested attributes
+type attr struct {
+	typ    uint16
+	data   []byte
+	nested []attr
+}
+
+func (a attr) encode() []byte {
+	data := a.data
+	for _, n := range a.nested {
+		data = append(data, n.encode()...)
+	}
+	b := make([]byte, 4, 4+len(data)+3)
+	native.PutUint16(b[0:2], uint16(4+len(data)))
+	native.PutUint16(b[2:4], a.typ)
+	b = append(b, data...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func stringAttr(typ uint16, s string) attr {
+	return attr{typ: typ, data: append([]byte(s), 0)}
+}
+
+func uint32Attr(typ uint16, v uint32) attr {
+	b := make([]byte, 4)
+	native.PutUint32(b, v)
+	return attr{typ: typ, data: b}
+}
+
+func uint16Attr(typ uint16, v uint16) attr {
+	b := make([]byte, 2)
+	native.PutUint16(b, v)
+	return attr{typ: typ, data: b}
+}
+
+func ipAttr(typ uint16, ip net.IP) attr {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return attr{typ: typ, data: []byte(ip)}
+}
+
+func ifInfoMsg(index int, flags, change uint32) []byte {
+	b := make([]byte, unix.SizeofIfInfomsg)
+	native.PutUint32(b[4:8], uint32(index))
+	native.PutUint32(b[8:12], flags)
+	native.PutUint32(b[12:16], change)
+	return b
+}
+
+func family(ip net.IP) uint8 {
+	if ip.To4() != nil {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// request sends a netlink route message and waits for its acknowledgement
+func request(typ, flags uint16, body []byte, attrs ...attr) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	for _, a := range attrs {
+		body = append(body, a.encode()...)
+	}
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(body))
+	native.PutUint32(msg[0:4], uint32(unix.SizeofNlMsghdr+len(body)))
+	native.PutUint16(msg[4:6], typ)
+	native.PutUint16(msg[6:8], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	native.PutUint32(msg[8:12], 1)
+	msg = append(msg, body...)
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return fmt.Errorf("short netlink error message")
+			}
+			if errno := int32(native.Uint32(m.Data[0:4])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+// linkAdd creates a link of kind, data are the attributes of the kind
+func linkAdd(name, kind string, parent int, data ...attr) error {
+	attrs := []attr{
+		stringAttr(unix.IFLA_IFNAME, name),
+		{typ: unix.IFLA_LINKINFO, nested: []attr{
+			stringAttr(unix.IFLA_INFO_KIND, kind),
+			{typ: unix.IFLA_INFO_DATA, nested: data},
+		}},
+	}
+	if parent > 0 {
+		attrs = append(attrs, uint32Attr(unix.IFLA_LINK, uint32(parent)))
+	}
+	return request(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, ifInfoMsg(0, 0, 0), attrs...)
+}
+
+func linkSetUp(index int, up bool) error {
+	flags := uint32(0)
+	if up {
+		flags = unix.IFF_UP
+	}
+	return request(unix.RTM_NEWLINK, 0, ifInfoMsg(index, flags, unix.IFF_UP))
+}
+
+func linkSetMTU(index, mtu int) error {
+	return request(unix.RTM_NEWLINK, 0, ifInfoMsg(index, 0, 0), uint32Attr(unix.IFLA_MTU, uint32(mtu)))
+}
+
+func linkSetMaster(index, master int) error {
+	return request(unix.RTM_NEWLINK, 0, ifInfoMsg(index, 0, 0), uint32Attr(unix.IFLA_MASTER, uint32(master)))
+}
+
+func addrAdd(index int, ip net.IP, ipNet *net.IPNet) error {
+	ones, _ := ipNet.Mask.Size()
+	body := make([]byte, unix.SizeofIfAddrmsg)
+	body[0] = family(ip)
+	body[1] = uint8(ones)
+	native.PutUint32(body[4:8], uint32(index))
+	attrs := []attr{ipAttr(unix.IFA_ADDRESS, ip)}
+	if family(ip) == unix.AF_INET {
+		attrs = append(attrs, ipAttr(unix.IFA_LOCAL, ip))
+	}
+	return request(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, body, attrs...)
+}
+
+// routeReplace adds the route to dst, or replaces the route to it
+func routeReplace(index int, dst *net.IPNet, via net.IP) error {
+	ones, _ := dst.Mask.Size()
+	body := make([]byte, unix.SizeofRtMsg)
+	body[0] = family(dst.IP)
+	body[1] = uint8(ones)
+	body[4] = unix.RT_TABLE_MAIN
+	body[5] = unix.RTPROT_STATIC
+	body[6] = unix.RT_SCOPE_UNIVERSE
+	body[7] = unix.RTN_UNICAST
+	attrs := []attr{uint32Attr(unix.RTA_OIF, uint32(index))}
+	if ones > 0 {
+		attrs = append(attrs, ipAttr(unix.RTA_DST, dst.IP))
+	}
+	if via != nil {
+		attrs = append(attrs, ipAttr(unix.RTA_GATEWAY, via))
+	} else {
+		body[6] = unix.RT_SCOPE_LINK
+	}
+	return request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, body, attrs...)
+}
+
+// route is an entry of the main routing table
+type route struct {
+	dst   string
+	via   string
+	index int
+}
+
+// routes returns the main routing table of both address families
+func routes() ([]route, error) {
+	var result []route
+	for _, fam := range []int{unix.AF_INET, unix.AF_INET6} {
+		rib, err := syscall.NetlinkRIB(unix.RTM_GETROUTE, fam)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(rib)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Type != unix.RTM_NEWROUTE || len(m.Data) < unix.SizeofRtMsg || m.Data[4] != unix.RT_TABLE_MAIN {
+				continue
+			}
+			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				return nil, err
+			}
+			r := route{}
+			dst := &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(int(m.Data[1]), 32)}
+			if fam == unix.AF_INET6 {
+				dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(int(m.Data[1]), 128)}
+			}
+			for _, a := range attrs {
+				switch a.Attr.Type {
+				case unix.RTA_DST:
+					dst.IP = net.IP(a.Value)
+				case unix.RTA_GATEWAY:
+					r.via = net.IP(a.Value).String()
+				case unix.RTA_OIF:
+					r.index = int(native.Uint32(a.Value))
+				}
+			}
+			r.dst = dst.String()
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
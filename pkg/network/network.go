@@ -0,0 +1,475 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/sirupsen/logrus"
+)
+
+// ConnmanConfigPath is the connman provisioning file of the interfaces connman manages
+const ConnmanConfigPath = "/var/lib/connman/k3os-network.config"
+
+// Configure creates the bonds, VLANs and bridges, in that order so each may use the ones before, and configures the
+// interfaces.  Physical interfaces are left to connman through its provisioning file, the virtual interfaces and
+// whatever connman cannot configure, such as the MTU, extra addresses and routes, are set with netlink.
+func Configure(t *target.Target, n *config.Network) error {
+	if n == nil {
+		n = &config.Network{}
+	}
+
+	for _, b := range n.Bonds {
+		if err := createBond(t, b); err != nil {
+			return fmt.Errorf("bond %s: %v", b.Name, err)
+		}
+	}
+	for _, v := range n.VLANs {
+		if err := createVLAN(t, v); err != nil {
+			return fmt.Errorf("vlan %s: %v", v.Name, err)
+		}
+	}
+	for _, b := range n.Bridges {
+		if err := createBridge(t, b); err != nil {
+			return fmt.Errorf("bridge %s: %v", b.Name, err)
+		}
+	}
+
+	content, err := connmanConfig(n)
+	if err != nil {
+		return err
+	}
+	if content == "" {
+		if err := t.Remove(ConnmanConfigPath); err != nil {
+			return err
+		}
+	} else {
+		if err := t.MkdirAll(filepath.Dir(ConnmanConfigPath), 0755); err != nil {
+			return err
+		}
+		if err := t.WriteFile(ConnmanConfigPath, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	virtual := virtualInterfaces(n)
+	for _, ifc := range n.Interfaces {
+		if err := configureInterface(t, ifc, virtual[ifc.Name]); err != nil {
+			return fmt.Errorf("interface %s: %v", interfaceName(ifc), err)
+		}
+	}
+	return nil
+}
+
+// Unmanaged returns the interfaces connman must leave alone, the virtual interfaces and the members of bonds and
+// bridges
+func Unmanaged(n *config.Network) []string {
+	if n == nil {
+		return nil
+	}
+	var (
+		result []string
+		seen   = map[string]bool{}
+	)
+	add := func(names ...string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+	for _, b := range n.Bonds {
+		add(b.Name)
+		add(b.Interfaces...)
+	}
+	for _, v := range n.VLANs {
+		add(v.Name)
+	}
+	for _, b := range n.Bridges {
+		add(b.Name)
+		add(b.Interfaces...)
+	}
+	return result
+}
+
+// Nameservers returns the nameservers of the virtual interfaces, connman only knows those of the interfaces it manages
+func Nameservers(n *config.Network) []string {
+	if n == nil {
+		return nil
+	}
+	virtual := virtualInterfaces(n)
+	var result []string
+	for _, ifc := range n.Interfaces {
+		if virtual[ifc.Name] {
+			result = append(result, ifc.Nameservers...)
+		}
+	}
+	return result
+}
+
+func virtualInterfaces(n *config.Network) map[string]bool {
+	result := map[string]bool{}
+	for _, b := range n.Bonds {
+		result[b.Name] = true
+	}
+	for _, v := range n.VLANs {
+		result[v.Name] = true
+	}
+	for _, b := range n.Bridges {
+		result[b.Name] = true
+	}
+	return result
+}
+
+func interfaceName(ifc config.NetworkInterface) string {
+	if ifc.Name != "" {
+		return ifc.Name
+	}
+	return ifc.MAC
+}
+
+// connmanConfig renders the provisioning file of the interfaces connman manages, it is empty if there are none
+func connmanConfig(n *config.Network) (string, error) {
+	unmanaged := map[string]bool{}
+	for _, name := range Unmanaged(n) {
+		unmanaged[name] = true
+	}
+
+	buf := &strings.Builder{}
+	for i, ifc := range n.Interfaces {
+		if ifc.Name == "" && ifc.MAC == "" {
+			return "", fmt.Errorf("network interface %d needs a name or a mac address", i)
+		}
+		if unmanaged[ifc.Name] {
+			continue
+		}
+		v4, v6, _, err := addresses(ifc)
+		if err != nil {
+			return "", fmt.Errorf("interface %s: %v", interfaceName(ifc), err)
+		}
+
+		fmt.Fprintf(buf, "[service_ethernet%d]\n", i)
+		buf.WriteString("Type=ethernet\n")
+		if ifc.MAC != "" {
+			fmt.Fprintf(buf, "MAC=%s\n", strings.ToLower(ifc.MAC))
+		} else {
+			fmt.Fprintf(buf, "DeviceName=%s\n", ifc.Name)
+		}
+		if v4 != nil {
+			fmt.Fprintf(buf, "IPv4=%s/%s", v4.IP, net.IP(v4.Mask))
+			if ifc.Gateway != "" {
+				fmt.Fprintf(buf, "/%s", ifc.Gateway)
+			}
+			buf.WriteString("\n")
+		} else if ifc.Gateway != "" {
+			return "", fmt.Errorf("interface %s: gateway %s needs an IPv4 address", interfaceName(ifc), ifc.Gateway)
+		}
+		if v6 != nil {
+			ones, _ := v6.Mask.Size()
+			fmt.Fprintf(buf, "IPv6=%s/%d", v6.IP, ones)
+			if ifc.Gateway6 != "" {
+				fmt.Fprintf(buf, "/%s", ifc.Gateway6)
+			}
+			buf.WriteString("\n")
+		} else if ifc.Gateway6 != "" {
+			return "", fmt.Errorf("interface %s: gateway6 %s needs an IPv6 address", interfaceName(ifc), ifc.Gateway6)
+		}
+		if len(ifc.Nameservers) > 0 {
+			fmt.Fprintf(buf, "Nameservers=%s\n", strings.Join(ifc.Nameservers, ","))
+		}
+	}
+
+	if buf.Len() == 0 {
+		return "", nil
+	}
+	return "[global]\n" +
+		"Name=k3os-network\n" +
+		"Description=Interfaces defined in k3os.network\n" +
+		buf.String(), nil
+}
+
+// addresses parses the addresses of an interface, the first IPv4 and IPv6 addresses are returned on their own as
+// those are the ones connman configures
+func addresses(ifc config.NetworkInterface) (v4, v6 *net.IPNet, rest []*net.IPNet, err error) {
+	for _, a := range ifc.Addresses {
+		ip, ipNet, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("address %q is not an address with a prefix length such as 10.0.0.2/24", a)
+		}
+		ipNet.IP = ip
+		switch {
+		case ip.To4() != nil && v4 == nil:
+			v4 = ipNet
+		case ip.To4() == nil && v6 == nil:
+			v6 = ipNet
+		default:
+			rest = append(rest, ipNet)
+		}
+	}
+	return
+}
+
+// configureInterface sets what connman does not with netlink, everything for virtual interfaces
+func configureInterface(t *target.Target, ifc config.NetworkInterface, virtual bool) error {
+	v4, v6, addrs, err := addresses(ifc)
+	if err != nil {
+		return err
+	}
+	var routes []config.Route
+	if virtual {
+		for _, a := range []*net.IPNet{v6, v4} {
+			if a != nil {
+				addrs = append([]*net.IPNet{a}, addrs...)
+			}
+		}
+		if ifc.Gateway != "" {
+			routes = append(routes, config.Route{To: "0.0.0.0/0", Via: ifc.Gateway})
+		}
+		if ifc.Gateway6 != "" {
+			routes = append(routes, config.Route{To: "::/0", Via: ifc.Gateway6})
+		}
+	}
+	routes = append(routes, ifc.Routes...)
+	if !virtual && ifc.MTU == 0 && len(addrs) == 0 && len(routes) == 0 {
+		return nil
+	}
+
+	name := ifc.Name
+	if name == "" {
+		if name = byMAC(ifc.MAC); name == "" {
+			if t.IsLive() {
+				logrus.Warnf("no interface has the mac address %s, it is left alone", ifc.MAC)
+			}
+			return nil
+		}
+	}
+
+	if ifc.MTU > 0 {
+		if iface, err := net.InterfaceByName(name); !t.IsLive() || err != nil || iface.MTU != ifc.MTU {
+			if err := t.Kernel(fmt.Sprintf("set MTU of %s to %d", name, ifc.MTU), func() error {
+				return withIndex(name, func(i int) error { return linkSetMTU(i, ifc.MTU) })
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	if virtual {
+		if err := setUp(t, name); err != nil {
+			return err
+		}
+	}
+	for _, a := range addrs {
+		if hasAddress(t, name, a) {
+			continue
+		}
+		a := a
+		if err := t.Kernel(fmt.Sprintf("add address %s to %s", a, name), func() error {
+			return withIndex(name, func(i int) error { return addrAdd(i, a.IP, a) })
+		}); err != nil {
+			return err
+		}
+	}
+	for _, r := range routes {
+		_, dst, err := net.ParseCIDR(r.To)
+		if err != nil {
+			return fmt.Errorf("route to %q is not a CIDR such as 10.1.0.0/16", r.To)
+		}
+		var via net.IP
+		if r.Via != "" {
+			if via = net.ParseIP(r.Via); via == nil {
+				return fmt.Errorf("route via %q is not an IP address", r.Via)
+			}
+		}
+		if hasRoute(t, name, dst, via) {
+			continue
+		}
+		description := fmt.Sprintf("add route to %s on %s", dst, name)
+		if via != nil {
+			description = fmt.Sprintf("add route to %s via %s on %s", dst, via, name)
+		}
+		if err := t.Kernel(description, func() error {
+			return withIndex(name, func(i int) error { return routeReplace(i, dst, via) })
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createBond(t *target.Target, b config.Bond) error {
+	mode := -1
+	for i, m := range config.BondModes {
+		if b.Mode == m || (b.Mode == "" && i == 0) {
+			mode = i
+		}
+	}
+	if mode < 0 {
+		return fmt.Errorf("unknown bond mode %q, must be one of %s", b.Mode, strings.Join(config.BondModes, ", "))
+	}
+	if !exists(t, b.Name) {
+		if err := t.Kernel(fmt.Sprintf("create bond %s in %s mode", b.Name, config.BondModes[mode]), func() error {
+			return linkAdd(b.Name, "bond", 0, attr{typ: iflaBondMode, data: []byte{uint8(mode)}})
+		}); err != nil {
+			return err
+		}
+	}
+	// the kernel only enslaves interfaces that are down to a bond
+	return enslave(t, b.Name, b.Interfaces, true)
+}
+
+func createVLAN(t *target.Target, v config.VLAN) error {
+	if v.Link == "" {
+		return fmt.Errorf("link is required")
+	}
+	if v.ID < 1 || v.ID > 4094 {
+		return fmt.Errorf("id %d is out of range, must be between 1 and 4094", v.ID)
+	}
+	if !exists(t, v.Name) {
+		if err := t.Kernel(fmt.Sprintf("create VLAN %s with ID %d on %s", v.Name, v.ID, v.Link), func() error {
+			return withIndex(v.Link, func(parent int) error {
+				return linkAdd(v.Name, "vlan", parent, uint16Attr(iflaVLANID, uint16(v.ID)))
+			})
+		}); err != nil {
+			return err
+		}
+	}
+	return setUp(t, v.Name)
+}
+
+func createBridge(t *target.Target, b config.Bridge) error {
+	if !exists(t, b.Name) {
+		if err := t.Kernel(fmt.Sprintf("create bridge %s", b.Name), func() error {
+			return linkAdd(b.Name, "bridge", 0)
+		}); err != nil {
+			return err
+		}
+	}
+	return enslave(t, b.Name, b.Interfaces, false)
+}
+
+// enslave adds the members to master and brings them all up
+func enslave(t *target.Target, master string, members []string, down bool) error {
+	for _, m := range members {
+		m := m
+		if t.IsLive() && masterOf(m) == master {
+			continue
+		}
+		if err := t.Kernel(fmt.Sprintf("add %s to %s", m, master), func() error {
+			return withIndex(master, func(masterIndex int) error {
+				return withIndex(m, func(i int) error {
+					if down {
+						if err := linkSetUp(i, false); err != nil {
+							return err
+						}
+					}
+					if err := linkSetMaster(i, masterIndex); err != nil {
+						return err
+					}
+					return linkSetUp(i, true)
+				})
+			})
+		}); err != nil {
+			return err
+		}
+	}
+	return setUp(t, master)
+}
+
+func setUp(t *target.Target, name string) error {
+	if iface, err := net.InterfaceByName(name); t.IsLive() && err == nil && iface.Flags&net.FlagUp != 0 {
+		return nil
+	}
+	return t.Kernel("bring up "+name, func() error {
+		return withIndex(name, func(i int) error { return linkSetUp(i, true) })
+	})
+}
+
+func withIndex(name string, fn func(int) error) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("interface %s: %v", name, err)
+	}
+	return fn(iface.Index)
+}
+
+func exists(t *target.Target, name string) bool {
+	if !t.IsLive() {
+		return false
+	}
+	_, err := net.InterfaceByName(name)
+	return err == nil
+}
+
+func masterOf(name string) string {
+	link, err := os.Readlink(filepath.Join("/sys/class/net", name, "master"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(link)
+}
+
+func byMAC(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return ""
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		if iface.HardwareAddr.String() == hw.String() {
+			return iface.Name
+		}
+	}
+	return ""
+}
+
+func hasAddress(t *target.Target, name string, a *net.IPNet) bool {
+	if !t.IsLive() {
+		return false
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return false
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.String() == a.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRoute(t *target.Target, name string, dst *net.IPNet, via net.IP) bool {
+	if !t.IsLive() {
+		return false
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return false
+	}
+	table, err := routes()
+	if err != nil {
+		return false
+	}
+	want := route{dst: dst.String(), index: iface.Index}
+	if via != nil {
+		want.via = via.String()
+	}
+	for _, r := range table {
+		if r == want {
+			return true
+		}
+	}
+	return false
+}
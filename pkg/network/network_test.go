@@ -0,0 +1,57 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+)
+
+func TestConnmanConfig(t *testing.T) {
+	n := &config.Network{
+		Interfaces: []config.NetworkInterface{
+			{
+				MAC:         "52:54:00:AB:CD:EF",
+				Addresses:   []string{"10.0.0.2/24", "fd00::2/64", "10.0.0.3/24"},
+				Gateway:     "10.0.0.1",
+				Gateway6:    "fd00::1",
+				Nameservers: []string{"10.0.0.53", "1.1.1.1"},
+			},
+			{Name: "eth1"},
+			{Name: "br0", Addresses: []string{"192.168.1.2/24"}, Gateway: "192.168.1.1"},
+			{Name: "eth2", MTU: 9000},
+		},
+		Bonds:   []config.Bond{{Name: "bond0", Interfaces: []string{"eth2", "eth3"}}},
+		Bridges: []config.Bridge{{Name: "br0", Interfaces: []string{"bond0"}}},
+	}
+
+	content, err := connmanConfig(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[global]
+Name=k3os-network
+Description=Interfaces defined in k3os.network
+[service_ethernet0]
+Type=ethernet
+MAC=52:54:00:ab:cd:ef
+IPv4=10.0.0.2/255.255.255.0/10.0.0.1
+IPv6=fd00::2/64/fd00::1
+Nameservers=10.0.0.53,1.1.1.1
+[service_ethernet1]
+Type=ethernet
+DeviceName=eth1
+`
+	if content != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, content)
+	}
+
+	if unmanaged := Unmanaged(n); !reflect.DeepEqual(unmanaged, []string{"bond0", "eth2", "eth3", "br0"}) {
+		t.Errorf("unexpected unmanaged interfaces %v", unmanaged)
+	}
+
+	n.Interfaces = []config.NetworkInterface{{Name: "eth0", Addresses: []string{"fd00::2/64"}, Gateway: "10.0.0.1"}}
+	if _, err := connmanConfig(n); err == nil {
+		t.Error("expected an error for a gateway without an IPv4 address")
+	}
+}
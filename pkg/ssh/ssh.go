@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
 	"github.com/rancher/k3os/pkg/util"
 	"github.com/sirupsen/logrus"
 )
@@ -21,29 +22,35 @@ const (
 	authorizedFile = "authorized_keys"
 )
 
-func SetAuthorizedKeys(cfg *config.CloudConfig, withNet bool) error {
-	bytes, err := ioutil.ReadFile("/etc/passwd")
+// SetAuthorizedKeys authorizes the top level sshAuthorizedKeys for config.DefaultUser
+func SetAuthorizedKeys(t *target.Target, cfg *config.CloudConfig, withNet bool) error {
+	bytes, err := t.ReadFile("/etc/passwd")
 	if err != nil {
 		return err
 	}
-	uid, gid, homeDir, err := findUserHomeDir(bytes, "rancher")
+	uid, gid, homeDir, err := findUserHomeDir(bytes, config.DefaultUser)
 	if err != nil {
 		return err
 	}
+	return AuthorizeKeys(t, uid, gid, homeDir, cfg.SSHAuthorizedKeys, withNet)
+}
+
+// AuthorizeKeys adds the keys to the authorized keys in homeDir of the user uid
+func AuthorizeKeys(t *target.Target, uid, gid int, homeDir string, keys []string, withNet bool) error {
 	userSSHDir := path.Join(homeDir, sshDir)
-	if _, err := os.Stat(userSSHDir); os.IsNotExist(err) {
-		if err = os.Mkdir(userSSHDir, 0700); err != nil {
+	if _, err := t.Stat(userSSHDir); os.IsNotExist(err) {
+		if err = t.MkdirAll(userSSHDir, 0700); err != nil {
 			return err
 		}
 	} else if err != nil {
 		return err
 	}
-	if err = os.Chown(userSSHDir, uid, gid); err != nil {
+	if err := t.Chown(userSSHDir, uid, gid); err != nil {
 		return err
 	}
 	userAuthorizedFile := path.Join(userSSHDir, authorizedFile)
-	for _, key := range cfg.SSHAuthorizedKeys {
-		if err = authorizeSSHKey(key, userAuthorizedFile, uid, gid, withNet); err != nil {
+	for _, key := range keys {
+		if err := authorizeSSHKey(t, key, userAuthorizedFile, uid, gid, withNet); err != nil {
 			logrus.Errorf("failed to authorize SSH key %s: %v", key, err)
 		}
 	}
@@ -72,7 +79,7 @@ func getKey(key string, withNet bool) (string, error) {
 	var resp *http.Response
 	for i := 0; i < 10; time.Sleep(time.Second) {
 		// network interface(s) can be up before DNS is ready, so let's try up to 10 times
-		resp, err = http.Get(key)
+		resp, err = util.HTTPClient().Get(key)
 		if err == nil || strings.Contains(err.Error(), "unsupported protocol scheme") {
 			break
 		}
@@ -91,63 +98,51 @@ func getKey(key string, withNet bool) (string, error) {
 	return string(bytes), err
 }
 
-func authorizeSSHKey(key, file string, uid, gid int, withNet bool) error {
+func authorizeSSHKey(t *target.Target, key, file string, uid, gid int, withNet bool) error {
 	key, err := getKey(key, withNet)
 	if err != nil || key == "" {
 		return err
 	}
 
-	info, err := os.Stat(file)
-	if os.IsNotExist(err) {
-		f, err := os.Create(file)
-		if err != nil {
-			return err
-		}
-		if err = f.Chmod(0600); err != nil {
-			return err
-		}
-		if err = f.Close(); err != nil {
-			return err
-		}
-		info, err = os.Stat(file)
+	perm := os.FileMode(0600)
+	bytes, err := t.ReadFile(file)
+	if err == nil {
+		info, err := t.Stat(file)
 		if err != nil {
 			return err
 		}
-	} else if err != nil {
-		return err
-	}
-	bytes, err := ioutil.ReadFile(file)
-	if err != nil {
+		perm = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
 		return err
 	}
 	if !strings.Contains(string(bytes), key) {
 		bytes = append(bytes, []byte(key)...)
 		bytes = append(bytes, '\n')
+		if err = t.WriteFileAtomic(file, bytes, perm); err != nil {
+			return err
+		}
 	}
-	perm := info.Mode().Perm()
-	if err = util.WriteFileAtomic(file, bytes, perm); err != nil {
-		return err
-	}
-	return os.Chown(file, uid, gid)
+	return t.Chown(file, uid, gid)
 }
 
 func findUserHomeDir(bytes []byte, username string) (uid, gid int, homeDir string, err error) {
 	for _, line := range strings.Split(string(bytes), "\n") {
-		if strings.HasPrefix(line, username) {
-			split := strings.Split(line, ":")
-			if len(split) < 6 {
-				break
-			}
-			uid, err = strconv.Atoi(split[2])
-			if err != nil {
-				return -1, -1, "", err
-			}
-			gid, err = strconv.Atoi(split[3])
-			if err != nil {
-				return -1, -1, "", err
-			}
-			homeDir = split[5]
+		split := strings.Split(line, ":")
+		if split[0] != username {
+			continue
+		}
+		if len(split) < 6 {
+			break
+		}
+		uid, err = strconv.Atoi(split[2])
+		if err != nil {
+			return -1, -1, "", err
+		}
+		gid, err = strconv.Atoi(split[3])
+		if err != nil {
+			return -1, -1, "", err
 		}
+		return uid, gid, split[5], nil
 	}
-	return
+	return -1, -1, "", fmt.Errorf("user %s does not exist", username)
 }
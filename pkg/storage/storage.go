@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+// mkfsFlags are the flags of mkfs for each filesystem, to overwrite without asking and to set the label
+var mkfsFlags = map[string]struct{ force, label string }{
+	"ext2":  {"-F", "-L"},
+	"ext3":  {"-F", "-L"},
+	"ext4":  {"-F", "-L"},
+	"xfs":   {"-f", "-L"},
+	"btrfs": {"-f", "-L"},
+	"vfat":  {"", "-n"},
+}
+
+// blkid probes the devices, a variable for the tests
+var blkid = "blkid"
+
+// partitionTimeout is how long to wait for the kernel to create the device of a new partition
+var partitionTimeout = 10 * time.Second
+
+// FormatDisks partitions and formats the disks.  A disk that already has the filesystem is left alone, one holding
+// anything else, a filesystem or a partition table, is only formatted when it is forced.
+func FormatDisks(t *target.Target, disks []config.Disk) error {
+	for _, d := range disks {
+		if err := formatDisk(t, d); err != nil {
+			return fmt.Errorf("disk %s: %v", d.Device, err)
+		}
+	}
+	return nil
+}
+
+func formatDisk(t *target.Target, d config.Disk) error {
+	flags, ok := mkfsFlags[d.Filesystem]
+	if !ok {
+		return fmt.Errorf("unsupported filesystem %q", d.Filesystem)
+	}
+	dev, err := resolveDevice(d.Device)
+	if err != nil {
+		return err
+	}
+	part := dev
+	if d.Partition {
+		part = partitionName(dev, 1)
+	}
+
+	// a partition that does not exist yet has nothing on it
+	if _, err := os.Stat(part); err == nil {
+		found, err := probe(part)
+		if err != nil {
+			return err
+		}
+		if found["TYPE"] == d.Filesystem {
+			return nil
+		}
+	}
+	found, err := probe(dev)
+	if err != nil {
+		return err
+	}
+	if found := describe(found); found != "" && !d.Force {
+		return fmt.Errorf("refusing to format %s, it holds %s, set force to format it anyway", dev, found)
+	}
+
+	if d.Partition {
+		parted := exec.Command("parted", "-s", dev, "mklabel", "gpt", "mkpart", "primary", "0%", "100%")
+		if err := t.Kernel("partition "+dev, func() error {
+			if err := run(parted); err != nil {
+				return err
+			}
+			return waitFor(part)
+		}); err != nil {
+			return err
+		}
+	}
+
+	args := []string{}
+	if flags.force != "" {
+		args = append(args, flags.force)
+	}
+	if d.Label != "" {
+		args = append(args, flags.label, d.Label)
+	}
+	args = append(append(args, d.MkfsOptions...), part)
+	mkfs := exec.Command("mkfs."+d.Filesystem, args...)
+	return t.Kernel(fmt.Sprintf("format %s as %s", part, d.Filesystem), func() error {
+		return run(mkfs)
+	})
+}
+
+// Mount mounts the filesystems, a mount point in use already is left alone
+func Mount(t *target.Target, mounts []config.Mount) error {
+	for _, m := range mounts {
+		if m.Path == "" || !filepath.IsAbs(m.Path) {
+			return fmt.Errorf("mount %s: path %q must be absolute", m.Device, m.Path)
+		}
+		if mounted, err := mount.Mounted(t.Path(m.Path)); err != nil {
+			return err
+		} else if mounted {
+			continue
+		}
+
+		dev := m.Device
+		if isDevice(dev) {
+			var err error
+			if dev, err = resolveDevice(dev); err != nil {
+				return fmt.Errorf("mount %s: %v", m.Path, err)
+			}
+		}
+		if err := t.MkdirAll(m.Path, 0755); err != nil {
+			return err
+		}
+		fs := m.Filesystem
+		if fs == "" {
+			found, err := probe(dev)
+			if err != nil {
+				return fmt.Errorf("mount %s: %v", m.Path, err)
+			}
+			fs = found["TYPE"]
+		}
+		if err := t.Kernel(fmt.Sprintf("mount %s on %s", dev, m.Path), func() error {
+			return mount.Mount(dev, t.Path(m.Path), fs, strings.Join(m.Options, ","))
+		}); err != nil {
+			return fmt.Errorf("mount %s: %v", m.Path, err)
+		}
+	}
+	return nil
+}
+
+// isDevice returns true if the mount device is a block device rather than, say, tmpfs or an NFS export
+func isDevice(dev string) bool {
+	if strings.HasPrefix(dev, "/dev/") || strings.HasPrefix(dev, "LABEL=") || strings.HasPrefix(dev, "UUID=") {
+		return true
+	}
+	_, err := os.Stat(filepath.Join("/dev/disk/by-id", dev))
+	return err == nil
+}
+
+// resolveDevice returns the device node of a path, a LABEL= or UUID= filesystem, or a name in /dev/disk/by-id
+func resolveDevice(dev string) (string, error) {
+	p := dev
+	switch {
+	case strings.HasPrefix(dev, "LABEL="):
+		p = filepath.Join("/dev/disk/by-label", strings.TrimPrefix(dev, "LABEL="))
+	case strings.HasPrefix(dev, "UUID="):
+		p = filepath.Join("/dev/disk/by-uuid", strings.TrimPrefix(dev, "UUID="))
+	case !filepath.IsAbs(dev):
+		p = filepath.Join("/dev/disk/by-id", dev)
+	}
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		return resolved, nil
+	}
+	// udev may not have created the link, blkid looks at the devices themselves
+	if strings.HasPrefix(dev, "LABEL=") || strings.HasPrefix(dev, "UUID=") {
+		if out, err := exec.Command("blkid", "-l", "-o", "device", "-t", dev).Output(); err == nil {
+			if resolved := strings.TrimSpace(string(out)); resolved != "" {
+				return resolved, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("device %s not found", dev)
+}
+
+// partitionName returns the device of partition n of the disk, disks ending with a digit have a p before the number
+func partitionName(disk string, n int) string {
+	if last := disk[len(disk)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", disk, n)
+	}
+	return fmt.Sprintf("%s%d", disk, n)
+}
+
+// probe returns what blkid finds on the device itself, not what its cache says.  Only exit status 2 means nothing
+// was found, any other failure, such as 8 for signatures that contradict each other, is an error so a disk is never
+// taken to be blank because blkid could not tell.
+func probe(dev string) (map[string]string, error) {
+	cmd := exec.Command(blkid, "-p", "-o", "export", dev)
+	out, err := cmd.Output()
+	if err == nil {
+		return parseBlkid(string(out)), nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	switch {
+	case ok && exitErr.ExitCode() == 2:
+		return map[string]string{}, nil
+	case ok:
+		return nil, fmt.Errorf("%s: %v: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return nil, fmt.Errorf("%s: %v", strings.Join(cmd.Args, " "), err)
+}
+
+func parseBlkid(out string) map[string]string {
+	result := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if parts := strings.SplitN(strings.TrimSpace(line), "=", 2); len(parts) == 2 {
+			result[parts[0]] = parts[1]
+		}
+	}
+	return result
+}
+
+// describe returns what the blkid probe found that formatting would destroy, or "" if the device is blank
+func describe(probe map[string]string) string {
+	switch {
+	case probe["TYPE"] != "":
+		return "a filesystem (" + probe["TYPE"] + ")"
+	case probe["PTTYPE"] != "":
+		return "a partition table (" + probe["PTTYPE"] + ")"
+	}
+	return ""
+}
+
+func waitFor(dev string) error {
+	for start := time.Now(); time.Since(start) < partitionTimeout; time.Sleep(100 * time.Millisecond) {
+		if _, err := os.Stat(dev); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s did not appear", dev)
+}
+
+func run(cmd *exec.Cmd) error {
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestPartitionName(t *testing.T) {
+	for disk, expected := range map[string]string{
+		"/dev/sdb":     "/dev/sdb1",
+		"/dev/vdc":     "/dev/vdc1",
+		"/dev/nvme1n1": "/dev/nvme1n1p1",
+		"/dev/mmcblk0": "/dev/mmcblk0p1",
+	} {
+		if got := partitionName(disk, 1); got != expected {
+			t.Errorf("%s: expected %s, got %s", disk, expected, got)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for out, expected := range map[string]string{
+		"": "",
+		"DEVNAME=/dev/sdb\nPTUUID=abc\nPTTYPE=gpt\n":                              "a partition table (gpt)",
+		"DEVNAME=/dev/sdb1\nUUID=123\nVERSION=1.0\nTYPE=ext4\nUSAGE=filesystem\n": "a filesystem (ext4)",
+	} {
+		if got := describe(parseBlkid(out)); got != expected {
+			t.Errorf("%q: expected %q, got %q", out, expected, got)
+		}
+	}
+}
+
+func TestFormatDiskProbeFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(cmd string) { blkid = cmd }(blkid)
+
+	disk := filepath.Join(dir, "disk")
+	if err := ioutil.WriteFile(disk, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "blkid")
+	for name, test := range map[string]struct {
+		script string
+		blank  bool
+	}{
+		"nothing found":   {"#!/bin/sh\nexit 2\n", true},
+		"ambivalent":      {"#!/bin/sh\necho 'ambivalent result' >&2\nexit 8\n", false},
+		"failed":          {"#!/bin/sh\nexit 4\n", false},
+		"missing command": {"", false},
+	} {
+		blkid = script
+		os.Remove(script)
+		if test.script != "" {
+			if err := ioutil.WriteFile(script, []byte(test.script), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tgt := target.New("/", true)
+		tgt.Out = ioutil.Discard
+		err := formatDisk(tgt, config.Disk{Device: disk, Filesystem: "ext4"})
+		if test.blank && (err != nil || len(tgt.Changes()) != 1) {
+			t.Errorf("%s: expected the blank disk to be formatted, got %v %v", name, tgt.Changes(), err)
+		}
+		if !test.blank && (err == nil || len(tgt.Changes()) != 0) {
+			t.Errorf("%s: expected the disk not to be formatted, got %v %v", name, tgt.Changes(), err)
+		}
+	}
+}
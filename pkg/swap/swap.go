@@ -0,0 +1,208 @@
+package swap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/paultag/go-modprobe"
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+	"github.com/rancher/k3os/pkg/util"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ZramDevice is the compressed swap in memory
+	ZramDevice = "/dev/zram0"
+
+	defaultZramSize = "25%"
+	// zram is used before the swap file
+	zramPriority = 100
+)
+
+// Configure sets up zram and the swap file and sets the swappiness.  Swap in use already is left alone, so running it
+// again changes nothing.
+func Configure(t *target.Target, s *config.Swap) error {
+	if s.Zram != nil {
+		if err := configureZram(t, s.Zram); err != nil {
+			return fmt.Errorf("zram: %v", err)
+		}
+	}
+	if s.File != "" {
+		if err := configureFile(t, s.File, s.FileSize); err != nil {
+			return fmt.Errorf("swap file %s: %v", s.File, err)
+		}
+	}
+	if s.Swappiness != nil {
+		val := strconv.Itoa(*s.Swappiness)
+		current, err := ioutil.ReadFile("/proc/sys/vm/swappiness")
+		if err == nil && strings.TrimSpace(string(current)) == val {
+			return nil
+		}
+		return t.Kernel("set swappiness to "+val, func() error {
+			return ioutil.WriteFile("/proc/sys/vm/swappiness", []byte(val), 0644)
+		})
+	}
+	return nil
+}
+
+func configureZram(t *target.Target, z *config.Zram) error {
+	if active(ZramDevice) {
+		return nil
+	}
+
+	size := z.Size
+	if size == "" {
+		size = defaultZramSize
+	}
+	n, err := zramSize(size)
+	if err != nil {
+		return err
+	}
+
+	return t.Kernel(fmt.Sprintf("set up %s of %s as swap", ZramDevice, size), func() error {
+		if _, err := os.Stat("/sys/block/zram0"); os.IsNotExist(err) {
+			if err := modprobe.Load("zram", "num_devices=1"); err != nil {
+				return err
+			}
+		}
+		if z.Algorithm != "" {
+			// only accepted before the size is set
+			if err := ioutil.WriteFile("/sys/block/zram0/comp_algorithm", []byte(z.Algorithm), 0644); err != nil {
+				return fmt.Errorf("failed to set the algorithm %s: %v", z.Algorithm, err)
+			}
+		}
+		if err := ioutil.WriteFile("/sys/block/zram0/disksize", []byte(strconv.FormatInt(n, 10)), 0644); err != nil {
+			return fmt.Errorf("failed to set the size: %v", err)
+		}
+		if err := run("mkswap", ZramDevice); err != nil {
+			return err
+		}
+		return run("swapon", "-p", strconv.Itoa(zramPriority), ZramDevice)
+	})
+}
+
+// zramSize returns the size in bytes, a percentage is of the memory of the system
+func zramSize(size string) (int64, error) {
+	if !strings.HasSuffix(size, "%") {
+		return util.ParseSize(size)
+	}
+	percent, err := strconv.Atoi(strings.TrimSuffix(size, "%"))
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("%q is not a percentage of the memory", size)
+	}
+	var info unix.Sysinfo_t
+	if err := unix.Sysinfo(&info); err != nil {
+		return 0, err
+	}
+	return int64(info.Totalram) * int64(info.Unit) * int64(percent) / 100, nil
+}
+
+// configureFile creates the swap file if it is missing and resizes it if it has another size, one in use is left
+// alone.  An existing file without the swap signature is refused, it holds something else.
+func configureFile(t *target.Target, file, size string) error {
+	if active(file) {
+		return nil
+	}
+	if size == "" {
+		return fmt.Errorf("file size is required")
+	}
+	n, err := util.ParseSize(size)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(t.Path(file))
+	exists := err == nil
+	switch {
+	case os.IsNotExist(err):
+		if err := t.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !info.Mode().IsRegular() || !isSwap(t.Path(file)):
+		return fmt.Errorf("exists and is not a swap file, refusing to overwrite it")
+	}
+	create := !exists || info.Size() != n
+	return t.Kernel(fmt.Sprintf("set up %s of %s as swap", file, size), func() error {
+		if create {
+			if err := allocate(t.Path(file), n, exists); err != nil {
+				return err
+			}
+			if err := run("mkswap", t.Path(file)); err != nil {
+				return err
+			}
+		}
+		return run("swapon", t.Path(file))
+	})
+}
+
+// allocate creates the file with its blocks allocated, the kernel does not swap to a sparse file.  An existing file is
+// only truncated when resize is set, configureFile checked it is a swap file.
+func allocate(file string, size int64, resize bool) error {
+	flags := os.O_CREATE | os.O_EXCL | os.O_WRONLY
+	if resize {
+		flags = os.O_TRUNC | os.O_WRONLY
+	}
+	f, err := os.OpenFile(file, flags, 0600)
+	if err != nil {
+		return err
+	}
+	if err := unix.Fallocate(int(f.Fd()), 0, 0, size); err != nil {
+		f.Close()
+		os.Remove(file)
+		return fmt.Errorf("failed to allocate %d bytes: %v", size, err)
+	}
+	return f.Close()
+}
+
+// isSwap returns true if the file has the signature mkswap writes at the end of the first page
+func isSwap(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	signature := make([]byte, 10)
+	if _, err := f.ReadAt(signature, int64(os.Getpagesize()-len(signature))); err != nil {
+		return false
+	}
+	return bytes.Equal(signature, []byte("SWAPSPACE2"))
+}
+
+// active returns true if the device or file is in use as swap
+func active(name string) bool {
+	f, err := os.Open("/proc/swaps")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return activeIn(f, name)
+}
+
+func activeIn(swaps io.Reader, name string) bool {
+	scanner := bufio.NewScanner(swaps)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 && fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
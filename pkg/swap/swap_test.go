@@ -0,0 +1,87 @@
+package swap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestActive(t *testing.T) {
+	swaps := `Filename				Type		Size	Used	Priority
+/dev/zram0                              partition	1015804	0	100
+/var/lib/swapfile                       file		2097148	0	-2
+`
+	for name, expected := range map[string]bool{
+		"/dev/zram0":        true,
+		"/var/lib/swapfile": true,
+		"/var/lib/swap":     false,
+	} {
+		if got := activeIn(strings.NewReader(swaps), name); got != expected {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestZramSize(t *testing.T) {
+	for size, expected := range map[string]int64{
+		"512M":  512 << 20,
+		"2G":    2 << 30,
+		"1GiB":  1 << 30,
+		"4096":  4096,
+		"100KB": 100 << 10,
+		"0":     -1,
+		"lots":  -1,
+		"150%":  -1,
+		"-1G":   -1,
+		"12.5G": -1,
+	} {
+		got, err := zramSize(size)
+		if expected < 0 {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %d", size, got)
+			}
+		} else if err != nil || got != expected {
+			t.Errorf("%s: expected %d, got %d, %v", size, expected, got, err)
+		}
+	}
+	if got, err := zramSize("50%"); err != nil || got <= 0 {
+		t.Errorf("50%%: expected half of the memory, got %d, %v", got, err)
+	}
+}
+
+func TestConfigureFileExisting(t *testing.T) {
+	root, err := ioutil.TempDir("", "swap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	data := filepath.Join(root, "data")
+	if err := ioutil.WriteFile(data, []byte("important"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := configureFile(target.New(root, false), "/data", "1M"); err == nil {
+		t.Error("expected a file without the swap signature to be refused")
+	}
+	if err := allocate(data, 1<<20, false); err == nil {
+		t.Error("expected allocate to refuse an existing file")
+	}
+	if content, err := ioutil.ReadFile(data); err != nil || string(content) != "important" {
+		t.Errorf("the file was overwritten: %q, %v", content, err)
+	}
+
+	swap := make([]byte, os.Getpagesize())
+	copy(swap[len(swap)-10:], "SWAPSPACE2")
+	if err := ioutil.WriteFile(filepath.Join(root, "swapfile"), swap, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"/swapfile", "/var/lib/swapfile"} {
+		if err := configureFile(target.New(root, false), file, "1M"); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+}
@@ -1,19 +1,23 @@
 package sysctl
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path"
 	"strings"
 
 	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
 )
 
-func ConfigureSysctl(cfg *config.CloudConfig) error {
+func ConfigureSysctl(t *target.Target, cfg *config.CloudConfig) error {
 	for k, v := range cfg.K3OS.Sysctls {
 		elements := []string{"/proc", "sys"}
 		elements = append(elements, strings.Split(k, ".")...)
 		path := path.Join(elements...)
-		if err := ioutil.WriteFile(path, []byte(v), 0644); err != nil {
+		if err := t.Kernel(fmt.Sprintf("set sysctl %s to %q", k, v), func() error {
+			return ioutil.WriteFile(path, []byte(v), 0644)
+		}); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,190 @@
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext = 3
+	// maxDiffCells limits the table of diffLines, which grows with the product of the changed lines of both files
+	maxDiffCells = 1 << 22
+)
+
+// Diff returns the unified diff of old and new, or nothing if they are the same.  Binary files and files too large to
+// compare are only reported as changed.
+func Diff(oldName, newName string, old, new []byte) string {
+	if bytes.Equal(old, new) {
+		return ""
+	}
+	a, b := splitLines(string(old)), splitLines(string(new))
+	if bytes.IndexByte(old, 0) >= 0 || bytes.IndexByte(new, 0) >= 0 || !diffable(a, b) {
+		return fmt.Sprintf("--- %s\n+++ %s\n# binary or large file changed, the diff is not shown\n", oldName, newName)
+	}
+	ops := diffLines(a, b)
+
+	buf := &strings.Builder{}
+	for _, h := range hunks(ops) {
+		if buf.Len() == 0 {
+			fmt.Fprintf(buf, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(h.aStart, h.aLen), hunkRange(h.bStart, h.bLen))
+		for _, op := range h.ops {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// commonLines returns how many lines a and b have in common at their start and, after those, at their end
+func commonLines(a, b []string) (int, int) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// diffable returns true if the table of diffLines for the lines that differ is small enough
+func diffable(a, b []string) bool {
+	prefix, suffix := commonLines(a, b)
+	n, m := len(a)-prefix-suffix, len(b)-prefix-suffix
+	return m == 0 || n <= maxDiffCells/m
+}
+
+// diffLines returns the edit script from a to b, the lines in common at the start and the end are kept and the rest
+// is based on the longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	prefix, suffix := commonLines(a, b)
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, lcsLines(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+func lcsLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+type hunk struct {
+	aStart, aLen, bStart, bLen int
+	ops                        []diffOp
+}
+
+// hunks groups the changes that are less than two contexts apart, with diffContext unchanged lines around them
+func hunks(ops []diffOp) []hunk {
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+
+	var result []hunk
+	for len(changes) > 0 {
+		last := 0
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*diffContext+1 {
+			last++
+		}
+		from, to := changes[0]-diffContext, changes[last]+diffContext+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(ops) {
+			to = len(ops)
+		}
+		changes = changes[last+1:]
+
+		h := hunk{aStart: 1, bStart: 1, ops: ops[from:to]}
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				h.aStart++
+			}
+			if op.kind != '-' {
+				h.bStart++
+			}
+		}
+		for _, op := range h.ops {
+			if op.kind != '+' {
+				h.aLen++
+			}
+			if op.kind != '-' {
+				h.bLen++
+			}
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
@@ -0,0 +1,375 @@
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/k3os/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// Target is the system the configuration is applied to, the files are under Root and commands run chrooted to it.
+// In DryRun mode nothing is changed, the diff of every file that would be written and every command that would run
+// are printed to Out instead.
+//
+// With Force set, work that is skipped when nothing changed since the last time, such as restarting k3s, is done
+// anyway.
+type Target struct {
+	Root   string
+	DryRun bool
+	Force  bool
+	Out    io.Writer
+
+	changes []string
+	secrets map[string]bool
+}
+
+// maxSymlinks is how many symbolic links Path follows before it stops resolving them, the same limit as Linux
+const maxSymlinks = 40
+
+// Live is the running system
+var Live = &Target{
+	Root: "/",
+	Out:  os.Stdout,
+}
+
+// New returns the Target for the root directory
+func New(root string, dryRun bool) *Target {
+	if root == "" {
+		root = "/"
+	}
+	return &Target{
+		Root:   filepath.Clean(root),
+		DryRun: dryRun,
+		Out:    os.Stdout,
+	}
+}
+
+// IsLive returns true if the target is the running system, so the kernel can be configured
+func (t *Target) IsLive() bool {
+	return t.Root == "/"
+}
+
+// Path returns the path on this system of the absolute path p on the target.  The symbolic links along p are
+// resolved within Root, as if the target was the root directory, so an absolute link or one with too many .. can not
+// lead out of it.
+func (t *Target) Path(p string) string {
+	return t.resolve(p, true)
+}
+
+// linkPath is like Path but does not follow p itself if it is a symbolic link, for the methods changing the link
+func (t *Target) linkPath(p string) string {
+	return t.resolve(p, false)
+}
+
+func (t *Target) resolve(p string, followLast bool) string {
+	if t.IsLive() {
+		return filepath.Join(t.Root, p)
+	}
+
+	resolved := "/"
+	rest := strings.Split(p, "/")
+	links := 0
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		if !followLast && len(rest) == 0 || links >= maxSymlinks {
+			resolved = next
+			continue
+		}
+		link, err := os.Readlink(filepath.Join(t.Root, next))
+		if err != nil {
+			resolved = next
+			continue
+		}
+		links++
+		if filepath.IsAbs(link) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(link, "/"), rest...)
+	}
+	return filepath.Join(t.Root, resolved)
+}
+
+// ReadFile reads the file p of the target
+func (t *Target) ReadFile(p string) ([]byte, error) {
+	return ioutil.ReadFile(t.Path(p))
+}
+
+// Stat returns the file info of p on the target
+func (t *Target) Stat(p string) (os.FileInfo, error) {
+	return os.Stat(t.Path(p))
+}
+
+// Open opens p on the target for reading
+func (t *Target) Open(p string) (*os.File, error) {
+	return os.Open(t.Path(p))
+}
+
+// Secret marks the files whose content is built from secret values, in DryRun mode their diff is not printed
+func (t *Target) Secret(paths ...string) {
+	if t.secrets == nil {
+		t.secrets = map[string]bool{}
+	}
+	for _, p := range paths {
+		t.secrets[filepath.Clean(p)] = true
+	}
+}
+
+// WriteFile writes the file p of the target, it is left alone if the content is the same
+func (t *Target) WriteFile(p string, data []byte, perm os.FileMode) error {
+	if t.same(p, data, perm, false) {
+		return nil
+	}
+	t.Changed("wrote %s", p)
+	if t.DryRun {
+		return t.diff(p, data, perm, false)
+	}
+	return ioutil.WriteFile(t.Path(p), data, perm)
+}
+
+// WriteFileAtomic is like WriteFile but replaces the file atomically, so the permissions are set too. The owner of
+// the file it replaces is kept.
+func (t *Target) WriteFileAtomic(p string, data []byte, perm os.FileMode) error {
+	if t.same(p, data, perm, true) {
+		return nil
+	}
+	t.Changed("wrote %s", p)
+	if t.DryRun {
+		return t.diff(p, data, perm, true)
+	}
+	return util.WriteFileAtomic(t.Path(p), data, perm)
+}
+
+// CopyFile copies the file src of the target to dst, it is left alone if it has the same content already.  Unlike
+// WriteFile the content is not read into memory, so it suits large files.
+func (t *Target) CopyFile(src, dst string, perm os.FileMode) error {
+	if same, err := t.sameFile(src, dst); err != nil {
+		return err
+	} else if same {
+		return nil
+	}
+	t.Changed("copied %s to %s", src, dst)
+	if t.DryRun {
+		t.printf("$ cp %s %s\n", src, dst)
+		return nil
+	}
+	return util.CopyFileAtomic(t.Path(src), t.Path(dst), perm)
+}
+
+// MkdirAll creates the directory p and its parents on the target
+func (t *Target) MkdirAll(p string, perm os.FileMode) error {
+	if _, err := t.Stat(p); err == nil {
+		return nil
+	}
+	t.Changed("created %s", p)
+	if t.DryRun {
+		t.printf("$ mkdir -p %s\n", p)
+		return nil
+	}
+	return os.MkdirAll(t.Path(p), perm)
+}
+
+// Remove removes the file p from the target, it is not an error if it does not exist
+func (t *Target) Remove(p string) error {
+	if _, err := os.Lstat(t.linkPath(p)); os.IsNotExist(err) {
+		return nil
+	}
+	t.Changed("removed %s", p)
+	if t.DryRun {
+		t.printf("$ rm %s\n", p)
+		return nil
+	}
+	return os.Remove(t.linkPath(p))
+}
+
+// Symlink makes p a symbolic link to oldname on the target, replacing what is there unless it links to oldname
+// already
+func (t *Target) Symlink(oldname, p string) error {
+	name := t.linkPath(p)
+	if current, err := os.Readlink(name); err == nil && current == oldname {
+		return nil
+	}
+	t.Changed("linked %s to %s", p, oldname)
+	if t.DryRun {
+		t.printf("$ ln -sf %s %s\n", oldname, p)
+		return nil
+	}
+	tmp := name + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(oldname, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Chown changes the owner of p on the target
+func (t *Target) Chown(p string, uid, gid int) error {
+	if info, err := t.Stat(p); err == nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) == uid && int(st.Gid) == gid {
+			return nil
+		}
+	}
+	t.Changed("changed owner of %s", p)
+	if t.DryRun {
+		t.printf("$ chown %d:%d %s\n", uid, gid, p)
+		return nil
+	}
+	return os.Chown(t.Path(p), uid, gid)
+}
+
+// Run runs the command in the target, chrooted if the target is not the running system
+func (t *Target) Run(cmd *exec.Cmd) error {
+	t.Changed("ran %s", describe(cmd))
+	if t.DryRun {
+		t.printf("$ %s\n", describe(cmd))
+		return nil
+	}
+	if !t.IsLive() {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Chroot = t.Root
+		if cmd.Dir == "" {
+			cmd.Dir = "/"
+		}
+	}
+	return cmd.Run()
+}
+
+// Kernel changes the running kernel with fn, such as loading a module or setting the hostname. It is skipped
+// for a target that is not the running system.
+func (t *Target) Kernel(description string, fn func() error) error {
+	if !t.IsLive() && !t.DryRun {
+		logrus.Debugf("skipping %s, %s is not the running system", description, t.Root)
+		return nil
+	}
+	t.Changed("%s", description)
+	if t.DryRun {
+		t.printf("# %s\n", description)
+		return nil
+	}
+	return fn()
+}
+
+// Changed records a change made to the target
+func (t *Target) Changed(format string, args ...interface{}) {
+	t.changes = append(t.changes, fmt.Sprintf(format, args...))
+}
+
+// Changes returns the changes made to the target so far
+func (t *Target) Changes() []string {
+	return t.changes
+}
+
+func (t *Target) printf(format string, args ...interface{}) {
+	out := t.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, format, args...)
+}
+
+// same returns true if p already has the content, and the permissions if checkPerm is set
+func (t *Target) same(p string, data []byte, perm os.FileMode, checkPerm bool) bool {
+	info, err := t.Stat(p)
+	if err != nil || checkPerm && info.Mode().Perm() != perm.Perm() {
+		return false
+	}
+	old, err := t.ReadFile(p)
+	return err == nil && bytes.Equal(old, data)
+}
+
+// sameFile returns true if dst exists with the same content as src
+func (t *Target) sameFile(src, dst string) (bool, error) {
+	srcInfo, err := t.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	dstInfo, err := t.Stat(dst)
+	if err != nil || dstInfo.Size() != srcInfo.Size() {
+		return false, nil
+	}
+	srcSum, err := util.SHA256File(t.Path(src))
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := util.SHA256File(t.Path(dst))
+	return err == nil && srcSum == dstSum, nil
+}
+
+func (t *Target) diff(p string, data []byte, perm os.FileMode, checkPerm bool) error {
+	name := t.Path(p)
+	oldName := name
+	old, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		oldName = "/dev/null"
+	} else if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(name); checkPerm && err == nil && info.Mode().Perm() != perm.Perm() {
+		t.printf("# mode of %s changes from %04o to %04o\n", name, info.Mode().Perm(), perm.Perm())
+	}
+	if t.secrets[filepath.Clean(p)] {
+		t.printf("--- %s\n+++ %s\n# the content is not shown, it holds secrets\n", oldName, name)
+		return nil
+	}
+	t.printf("%s", Diff(oldName, name, old, data))
+	return nil
+}
+
+// secretEnv are the parts of environment variable names whose values are not printed
+var secretEnv = []string{"TOKEN", "SECRET", "PASSWORD", "PASSPHRASE"}
+
+// describe returns the command line of cmd with the environment variables it adds
+func describe(cmd *exec.Cmd) string {
+	inherited := map[string]bool{}
+	for _, env := range os.Environ() {
+		inherited[env] = true
+	}
+
+	var parts []string
+	for _, env := range cmd.Env {
+		if inherited[env] {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimSpace(env), "=", 2)
+		for _, s := range secretEnv {
+			if len(kv) == 2 && strings.Contains(strings.ToUpper(kv[0]), s) {
+				env = kv[0] + "=<redacted>"
+			}
+		}
+		parts = append(parts, strings.TrimSpace(env))
+	}
+	sort.Strings(parts)
+
+	args := cmd.Args
+	if len(args) == 0 {
+		args = []string{cmd.Path}
+	}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"$;&|<>*?`\\") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
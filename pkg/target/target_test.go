@@ -0,0 +1,179 @@
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	new := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	expected := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if diff := Diff("old", "new", []byte(old), []byte(new)); diff != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", diff, expected)
+	}
+	if diff := Diff("old", "new", []byte(old), []byte(old)); diff != "" {
+		t.Errorf("expected no diff, got:\n%s", diff)
+	}
+
+	notShown := "--- old\n+++ new\n# binary or large file changed, the diff is not shown\n"
+	if diff := Diff("old", "new", []byte("a\x00b"), []byte("a\x00c")); diff != notShown {
+		t.Errorf("expected a binary file, got:\n%s", diff)
+	}
+	large := func(prefix string) []byte {
+		buf := &bytes.Buffer{}
+		for i := 0; i < 3000; i++ {
+			fmt.Fprintf(buf, "%s%d\n", prefix, i)
+		}
+		return buf.Bytes()
+	}
+	if diff := Diff("old", "new", large("a"), large("b")); diff != notShown {
+		t.Errorf("expected a large file, got %d bytes", len(diff))
+	}
+	// only the lines that differ count
+	if diff := Diff("old", "new", large("a"), append(large("a"), "b\n"...)); !strings.HasSuffix(diff, " a2999\n+b\n") {
+		t.Errorf("expected the added line, got:\n%s", diff)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	root, err := ioutil.TempDir("", "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/hostname"), []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	target := New(root, true)
+	target.Out = out
+
+	if err := target.WriteFile("/etc/hostname", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.MkdirAll("/var/lib/connman", 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", "echo hi")
+	cmd.Env = append(os.Environ(), "K3S_TOKEN=secret", "K3S_URL=https://server:6443")
+	if err := target.Run(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Kernel("set hostname to new", func() error {
+		t.Error("kernel change made in dry-run")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(root, "etc/hostname")
+	expected := "--- " + name + "\n+++ " + name + "\n@@ -1 +1 @@\n-old\n+new\n" +
+		"$ mkdir -p /var/lib/connman\n" +
+		"$ K3S_TOKEN=<redacted> K3S_URL=https://server:6443 sh -c 'echo hi'\n" +
+		"# set hostname to new\n"
+	if out.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	if content, _ := ioutil.ReadFile(name); string(content) != "old\n" {
+		t.Errorf("file was changed in dry-run: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(root, "var")); !os.IsNotExist(err) {
+		t.Errorf("directory was created in dry-run: %v", err)
+	}
+}
+
+func TestDryRunSecret(t *testing.T) {
+	root, err := ioutil.TempDir("", "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	out := &bytes.Buffer{}
+	target := New(root, true)
+	target.Out = out
+	target.Secret("/etc/k3s.yaml")
+	if err := target.WriteFile("/etc/k3s.yaml", []byte("token: secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(root, "etc/k3s.yaml")
+	expected := "--- /dev/null\n+++ " + name + "\n# the content is not shown, it holds secrets\n"
+	if out.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, dest := range map[string]string{
+		"etc/absolute": "/data",
+		"etc/relative": "../../../../data",
+		"etc/loop":     "loop",
+	} {
+		if err := os.Symlink(dest, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := New(root, false)
+	for p, expected := range map[string]string{
+		"/etc/hostname":          "/etc/hostname",
+		"/../../etc/hostname":    "/etc/hostname",
+		"/etc/absolute/file":     "/data/file",
+		"/etc/relative/file":     "/data/file",
+		"/etc/absolute/../other": "/other",
+		"/etc/loop/file":         "/etc/loop/file",
+	} {
+		if got := target.Path(p); got != filepath.Join(root, expected) {
+			t.Errorf("%s: expected %s, got %s", p, filepath.Join(root, expected), got)
+		}
+	}
+
+	// the link itself is replaced, not the file it leads to
+	if err := target.Symlink("/etc/hostname", "/etc/absolute"); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(filepath.Join(root, "etc/absolute")); err != nil || link != "/etc/hostname" {
+		t.Errorf("unexpected link %q: %v", link, err)
+	}
+	if err := target.Remove("/etc/relative"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "etc/relative")); !os.IsNotExist(err) {
+		t.Errorf("expected the link to be removed: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package timesync
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3os/pkg/target"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ntpEpoch is the seconds from 1900, where NTP time starts, to 1970
+	ntpEpoch = 2208988800
+	// poolSamples is how many of the addresses of a pool are asked
+	poolSamples = 4
+)
+
+var queryTimeout = 5 * time.Second
+
+// syncSNTP asks every server and some of the addresses of every pool for the time, and steps the clock by the median
+// offset when it is above the threshold.  Unlike chrony it does not slew the clock, so it is only set when k3os
+// applies the configuration.
+func syncSNTP(t *target.Target, servers, pools []string, threshold time.Duration) (string, error) {
+	addrs := append([]string{}, servers...)
+	for _, p := range pools {
+		found, err := net.LookupHost(p)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve pool %s: %v", p, err)
+		}
+		if len(found) > poolSamples {
+			found = found[:poolSamples]
+		}
+		addrs = append(addrs, found...)
+	}
+
+	var (
+		offsets []time.Duration
+		errs    []string
+	)
+	for _, addr := range addrs {
+		offset, err := query(addr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) == 0 {
+		return "", fmt.Errorf("no time server answered: %s", strings.Join(errs, "; "))
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	offset := offsets[len(offsets)/2].Round(time.Microsecond)
+
+	if offset > threshold || -offset > threshold {
+		if err := t.Kernel(fmt.Sprintf("step the clock by %s", offset), func() error {
+			tv := unix.NsecToTimeval(time.Now().Add(offset).UnixNano())
+			return unix.Settimeofday(&tv)
+		}); err != nil {
+			return "", fmt.Errorf("failed to set the clock: %v", err)
+		}
+		return fmt.Sprintf("synchronised with %d of %d servers, stepped the clock by %s", len(offsets), len(addrs),
+			offset), nil
+	}
+	return fmt.Sprintf("synchronised with %d of %d servers, the clock is off by %s", len(offsets), len(addrs), offset),
+		nil
+}
+
+// query returns how far the clock is behind the server
+func query(server string) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(server, "123"), queryTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(queryTimeout)); err != nil {
+		return 0, err
+	}
+
+	req := make([]byte, 48)
+	// no leap second warning, version 4, client mode
+	req[0] = 0<<6 | 4<<3 | 3
+	sent := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTP(sent))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	return offset(resp[:n], sent, time.Now())
+}
+
+// offset returns how far the clock is behind the server from its response to a request sent and received at the
+// local times, as the mean of the difference when the server received the request and when it answered
+func offset(resp []byte, sent, received time.Time) (time.Duration, error) {
+	if len(resp) < 48 {
+		return 0, fmt.Errorf("short response of %d bytes", len(resp))
+	}
+	if mode := resp[0] & 0x7; mode != 4 {
+		return 0, fmt.Errorf("unexpected response mode %d", mode)
+	}
+	if resp[0]>>6 == 3 {
+		return 0, fmt.Errorf("the server is not synchronised")
+	}
+	if resp[1] == 0 {
+		return 0, fmt.Errorf("the server refused the request (%s)", strings.TrimRight(string(resp[12:16]), "\x00"))
+	}
+	if binary.BigEndian.Uint64(resp[24:]) != toNTP(sent) {
+		return 0, fmt.Errorf("the response is not for the request")
+	}
+	serverReceived := fromNTP(binary.BigEndian.Uint64(resp[32:]))
+	serverSent := fromNTP(binary.BigEndian.Uint64(resp[40:]))
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+func toNTP(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpoch)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTP(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpoch
+	nsec := (v & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(secs, int64(nsec))
+}
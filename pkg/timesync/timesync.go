@@ -0,0 +1,191 @@
+package timesync
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+const (
+	// ChronyConfigPath is written when chrony is installed, chronyd then keeps the clock in sync
+	ChronyConfigPath = "/etc/chrony/chrony.conf"
+	// HWClockConfigPath is read by the hwclock service, which saves the time to the hardware clock when it stops
+	HWClockConfigPath = "/etc/conf.d/hwclock"
+	// ZoneinfoDir holds the timezones of tzdata
+	ZoneinfoDir = "/usr/share/zoneinfo"
+	// DefaultPool is used when neither k3os.time nor k3os.ntpServers name a server
+	DefaultPool = "pool.ntp.org"
+
+	chronyd         = "/usr/sbin/chronyd"
+	chronydService  = "/etc/init.d/chronyd"
+	chronydRunlevel = "/etc/runlevels/default/chronyd"
+)
+
+// Configure sets the timezone and whether the time is saved to the hardware clock on shutdown, and keeps the clock in
+// sync with chrony, or with the built-in SNTP client when chrony is not installed.  With sync set the network is
+// expected to be up, chronyd is restarted if its configuration changed and the built-in client sets the clock.  It
+// returns the sync status.
+func Configure(t *target.Target, ts *config.TimeSync, ntpServers []string, sync bool) (string, error) {
+	if err := setTimezone(t, ts.Timezone); err != nil {
+		return "", err
+	}
+	if err := setHWClockSync(t, ts.HWClockSync); err != nil {
+		return "", err
+	}
+
+	servers, pools := ts.Servers, ts.Pools
+	if len(servers) == 0 && len(pools) == 0 {
+		if servers = ntpServers; len(servers) == 0 {
+			pools = []string{DefaultPool}
+		}
+	}
+	threshold, _, err := ts.Step()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := t.Stat(chronyd); err == nil {
+		return configureChrony(t, chronyConfig(ts, servers, pools), sync)
+	}
+	if !sync || !t.IsLive() {
+		return "the clock is set by the built-in SNTP client on the running system once the network is up", nil
+	}
+	return syncSNTP(t, servers, pools, threshold)
+}
+
+// chronyConfig renders chrony.conf, rtcsync has the kernel keep the hardware clock in sync while running too
+func chronyConfig(ts *config.TimeSync, servers, pools []string) []byte {
+	makestep := ts.Makestep
+	if makestep == "" {
+		makestep = config.DefaultMakestep
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("# written by k3os from k3os.time\n")
+	for _, s := range servers {
+		fmt.Fprintf(buf, "server %s iburst\n", s)
+	}
+	for _, p := range pools {
+		fmt.Fprintf(buf, "pool %s iburst\n", p)
+	}
+	fmt.Fprintf(buf, "makestep %s\n", strings.Join(strings.Fields(makestep), " "))
+	buf.WriteString("driftfile /var/lib/chrony/chrony.drift\n")
+	if ts.HWClockSync {
+		buf.WriteString("rtcsync\n")
+	}
+	return buf.Bytes()
+}
+
+// configureChrony writes the configuration and enables chronyd, it starts with the default runlevel at boot
+func configureChrony(t *target.Target, conf []byte, sync bool) (string, error) {
+	old, _ := t.ReadFile(ChronyConfigPath)
+	if err := t.MkdirAll(filepath.Dir(ChronyConfigPath), 0755); err != nil {
+		return "", err
+	}
+	if err := t.WriteFile(ChronyConfigPath, conf, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", ChronyConfigPath, err)
+	}
+	if err := t.MkdirAll(filepath.Dir(chronydRunlevel), 0755); err != nil {
+		return "", err
+	}
+	if err := t.Symlink(chronydService, chronydRunlevel); err != nil {
+		return "", err
+	}
+	if !sync || !t.IsLive() {
+		return "chronyd keeps the clock in sync once it is started", nil
+	}
+
+	if !bytes.Equal(old, conf) {
+		if err := t.Kernel("restart chronyd", func() error {
+			cmd := exec.Command("rc-service", "chronyd", "restart")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("%s: %v: %s", strings.Join(cmd.Args, " "), err, strings.TrimSpace(string(out)))
+			}
+			return nil
+		}); err != nil {
+			return "", err
+		}
+	}
+	out, err := exec.Command("chronyc", "-n", "tracking").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the status of chronyd: %v", err)
+	}
+	return trackingStatus(out), nil
+}
+
+// trackingStatus returns the sync status from the output of chronyc tracking
+func trackingStatus(out []byte) string {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if parts := strings.SplitN(scanner.Text(), ":", 2); len(parts) == 2 {
+			fields[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	if fields["Leap status"] == "" || fields["Leap status"] == "Not synchronised" {
+		return "chronyd is not synchronised yet"
+	}
+	ref := fields["Reference ID"]
+	if i := strings.Index(ref, "("); i >= 0 {
+		ref = strings.TrimSuffix(ref[i+1:], ")")
+	}
+	return fmt.Sprintf("chronyd is synchronised with %s, the clock is %s", ref, fields["System time"])
+}
+
+// setTimezone links /etc/localtime to the timezone, which must be installed
+func setTimezone(t *target.Target, tz string) error {
+	if tz == "" {
+		return nil
+	}
+	zone := filepath.Join(ZoneinfoDir, filepath.Clean("/"+tz))
+	if _, err := t.Stat(zone); err != nil {
+		return fmt.Errorf("unknown timezone %s: %v", tz, err)
+	}
+	if err := t.Symlink(zone, "/etc/localtime"); err != nil {
+		return err
+	}
+	return t.WriteFile("/etc/timezone", []byte(tz+"\n"), 0644)
+}
+
+func setHWClockSync(t *target.Target, sync bool) error {
+	data, err := t.ReadFile(HWClockConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	value := "NO"
+	if sync {
+		value = "YES"
+	}
+	if err := t.MkdirAll(filepath.Dir(HWClockConfigPath), 0755); err != nil {
+		return err
+	}
+	return t.WriteFile(HWClockConfigPath, setVariable(data, "clock_systohc", value), 0644)
+}
+
+// setVariable sets a variable of a shell configuration file, where it is commented out too, the other lines are kept
+// as they are
+func setVariable(data []byte, key, value string) []byte {
+	line := fmt.Sprintf("%s=%q", key, value)
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	found := false
+	for i, l := range lines {
+		if strings.HasPrefix(strings.TrimLeft(l, "# \t"), key+"=") {
+			lines[i] = line
+			found = true
+		}
+	}
+	if !found {
+		lines = append(lines, line)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
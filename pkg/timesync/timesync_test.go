@@ -0,0 +1,122 @@
+package timesync
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestOffset(t *testing.T) {
+	sent := time.Date(2020, 3, 1, 12, 0, 0, 250000000, time.UTC)
+	received := sent.Add(40 * time.Millisecond)
+	// the server is 2s ahead, the request and the response take 20ms each
+	resp := make([]byte, 48)
+	resp[0] = 4<<3 | 4
+	resp[1] = 2
+	binary.BigEndian.PutUint64(resp[24:], toNTP(sent))
+	binary.BigEndian.PutUint64(resp[32:], toNTP(sent.Add(2*time.Second+20*time.Millisecond)))
+	binary.BigEndian.PutUint64(resp[40:], toNTP(sent.Add(2*time.Second+20*time.Millisecond)))
+
+	got, err := offset(resp, sent, received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Round(time.Microsecond) != 2*time.Second {
+		t.Errorf("expected an offset of 2s, got %s", got)
+	}
+
+	kiss := append([]byte{}, resp...)
+	kiss[1] = 0
+	copy(kiss[12:], "RATE")
+	if _, err := offset(kiss, sent, received); err == nil || err.Error() != "the server refused the request (RATE)" {
+		t.Errorf("expected the kiss code, got %v", err)
+	}
+	if _, err := offset(resp, sent.Add(time.Second), received); err == nil {
+		t.Error("expected an error for a response to another request")
+	}
+}
+
+func TestChronyConfig(t *testing.T) {
+	ts := &config.TimeSync{
+		Servers:     []string{"10.0.0.1"},
+		Pools:       []string{"2.pool.ntp.org"},
+		Makestep:    "0.5  -1",
+		HWClockSync: true,
+	}
+	expected := `# written by k3os from k3os.time
+server 10.0.0.1 iburst
+pool 2.pool.ntp.org iburst
+makestep 0.5 -1
+driftfile /var/lib/chrony/chrony.drift
+rtcsync
+`
+	if got := string(chronyConfig(ts, ts.Servers, ts.Pools)); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	root, err := ioutil.TempDir("", "timesync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"etc/conf.d", "usr/share/zoneinfo/Europe", "usr/sbin"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p, content := range map[string]string{
+		"usr/share/zoneinfo/Europe/Berlin": "TZif",
+		"usr/sbin/chronyd":                 "",
+		HWClockConfigPath:                  "clock=\"UTC\"\n#clock_systohc=\"NO\"\nclock_args=\"\"\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, p), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts := &config.TimeSync{Timezone: "Europe/Berlin", HWClockSync: true}
+	if _, err := Configure(target.New(root, false), ts, []string{"ntp.internal"}, true); err != nil {
+		t.Fatal(err)
+	}
+	link, err := os.Readlink(filepath.Join(root, "etc/localtime"))
+	if err != nil || link != "/usr/share/zoneinfo/Europe/Berlin" {
+		t.Errorf("unexpected /etc/localtime link %q: %v", link, err)
+	}
+	if hwclock, _ := ioutil.ReadFile(filepath.Join(root, HWClockConfigPath)); string(hwclock) !=
+		"clock=\"UTC\"\nclock_systohc=\"YES\"\nclock_args=\"\"\n" {
+		t.Errorf("unexpected %s:\n%s", HWClockConfigPath, hwclock)
+	}
+	if chrony, _ := ioutil.ReadFile(filepath.Join(root, ChronyConfigPath)); string(chrony) !=
+		string(chronyConfig(ts, []string{"ntp.internal"}, nil)) {
+		t.Errorf("unexpected %s:\n%s", ChronyConfigPath, chrony)
+	}
+	if link, err := os.Readlink(filepath.Join(root, chronydRunlevel)); err != nil || link != chronydService {
+		t.Errorf("chronyd is not enabled: %q, %v", link, err)
+	}
+
+	ts.Timezone = "Mars/Olympus_Mons"
+	if _, err := Configure(target.New(root, false), ts, nil, true); err == nil {
+		t.Error("expected an error for an unknown timezone")
+	}
+}
+
+func TestTrackingStatus(t *testing.T) {
+	for out, expected := range map[string]string{
+		"Reference ID    : C0A80101 (192.168.1.1)\nStratum         : 3\n" +
+			"System time     : 0.000012345 seconds slow of NTP time\nLeap status     : Normal\n": "chronyd is " +
+			"synchronised with 192.168.1.1, the clock is 0.000012345 seconds slow of NTP time",
+		"Reference ID    : 00000000 ()\nLeap status     : Not synchronised\n": "chronyd is not synchronised yet",
+	} {
+		if got := trackingStatus([]byte(out)); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package users
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/k3os/pkg/target"
+)
+
+// fieldCounts are the number of fields of an entry in each file
+var fieldCounts = map[string]int{
+	"/etc/passwd": 7,
+	"/etc/group":  4,
+	"/etc/shadow": 9,
+}
+
+// db is one of the files of colon separated entries keyed by name, such as /etc/passwd.  Lines that are not
+// entries are kept as they are.
+type db struct {
+	path  string
+	perm  os.FileMode
+	lines []string
+}
+
+func readDB(t *target.Target, p string, perm os.FileMode) (*db, error) {
+	d := &db{path: p, perm: perm}
+	bytes, err := t.ReadFile(p)
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if info, err := t.Stat(p); err == nil {
+		d.perm = info.Mode().Perm()
+	}
+	content := strings.TrimSuffix(string(bytes), "\n")
+	if content != "" {
+		d.lines = strings.Split(content, "\n")
+	}
+	return d, nil
+}
+
+// find returns the fields of the entry of name, padded to the number of fields of the file, or nil
+func (d *db) find(name string) []string {
+	for _, line := range d.lines {
+		if fields := strings.Split(line, ":"); fields[0] == name {
+			return d.pad(fields)
+		}
+	}
+	return nil
+}
+
+// findID returns the fields of the entry with the ID in field i, or nil
+func (d *db) findID(i int, id string) []string {
+	for _, line := range d.lines {
+		if fields := strings.Split(line, ":"); len(fields) > i && fields[i] == id {
+			return d.pad(fields)
+		}
+	}
+	return nil
+}
+
+// nextID returns the lowest free ID in field i from first on
+func (d *db) nextID(i, first int) int {
+	used := map[int]bool{}
+	for _, line := range d.lines {
+		if fields := strings.Split(line, ":"); len(fields) > i {
+			if id, err := strconv.Atoi(fields[i]); err == nil {
+				used[id] = true
+			}
+		}
+	}
+	id := first
+	for used[id] && id < lastID {
+		id++
+	}
+	return id
+}
+
+// set replaces the entry with the same name as fields, or adds it
+func (d *db) set(fields []string) {
+	line := strings.Join(fields, ":")
+	for i, l := range d.lines {
+		if strings.Split(l, ":")[0] == fields[0] {
+			d.lines[i] = line
+			return
+		}
+	}
+	d.lines = append(d.lines, line)
+}
+
+func (d *db) pad(fields []string) []string {
+	for len(fields) < fieldCounts[d.path] {
+		fields = append(fields, "")
+	}
+	return fields
+}
+
+// write replaces the file atomically, keeping its owner and mode
+func (d *db) write(t *target.Target) error {
+	content := strings.Join(d.lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return t.WriteFileAtomic(d.path, []byte(content), d.perm)
+}
@@ -0,0 +1,300 @@
+package users
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/ssh"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+const (
+	// SudoersFile holds the sudo rules of the users
+	SudoersFile = "/etc/sudoers.d/users"
+
+	defaultShell = "/bin/sh"
+	firstID      = 1000
+	lastID       = 60000
+	visudo       = "/usr/sbin/visudo"
+)
+
+// defaultSudo is the rule of config.DefaultUser unless its sudo rules are configured
+var defaultSudo = []string{"ALL = (ALL) NOPASSWD: ALL"}
+
+// ConfigureUsers creates or updates the groups and the users by editing /etc/group, /etc/passwd and /etc/shadow, and
+// writes their sudo rules and authorized SSH keys.  The sudo rules are written even if a user fails, so
+// config.DefaultUser keeps its rule.
+func ConfigureUsers(t *target.Target, cfg *config.CloudConfig, withNet bool) error {
+	err := configureUsers(t, cfg, withNet)
+	if sudoErr := writeSudoers(t, cfg); err == nil {
+		err = sudoErr
+	}
+	return err
+}
+
+func configureUsers(t *target.Target, cfg *config.CloudConfig, withNet bool) error {
+	passwd, err := readDB(t, "/etc/passwd", 0644)
+	if err != nil {
+		return err
+	}
+	group, err := readDB(t, "/etc/group", 0644)
+	if err != nil {
+		return err
+	}
+	t.Secret("/etc/shadow")
+	shadow, err := readDB(t, "/etc/shadow", 0640)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range cfg.Groups {
+		if err := setGroup(group, g); err != nil {
+			return err
+		}
+	}
+	for _, u := range cfg.Users {
+		if err := setUser(passwd, group, shadow, u); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range []*db{group, passwd, shadow} {
+		if err := d.write(t); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range cfg.Users {
+		fields := passwd.find(u.Name)
+		uid, _ := strconv.Atoi(fields[2])
+		gid, _ := strconv.Atoi(fields[3])
+		home := fields[5]
+		if _, err := t.Stat(home); os.IsNotExist(err) {
+			if err := t.MkdirAll(home, 0755); err != nil {
+				return err
+			}
+			if err := t.Chown(home, uid, gid); err != nil {
+				return err
+			}
+		}
+		if len(u.SSHAuthorizedKeys) > 0 {
+			if err := ssh.AuthorizeKeys(t, uid, gid, home, u.SSHAuthorizedKeys, withNet); err != nil {
+				return fmt.Errorf("user %s: %v", u.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func setGroup(group *db, g config.Group) error {
+	if g.Name == "" {
+		return fmt.Errorf("group name is required")
+	}
+	if err := checkFields("group "+g.Name, map[string]string{"name": g.Name}); err != nil {
+		return err
+	}
+	for _, member := range g.Members {
+		if err := checkFields("group "+g.Name, map[string]string{"member": member}); err != nil {
+			return err
+		}
+		if strings.Contains(member, ",") {
+			return fmt.Errorf("group %s: member %q must not contain a comma", g.Name, member)
+		}
+	}
+	fields := group.find(g.Name)
+	if fields == nil {
+		gid := g.GID
+		if gid == 0 {
+			gid = group.nextID(2, firstID)
+		}
+		fields = []string{g.Name, "x", strconv.Itoa(gid), ""}
+	} else if g.GID != 0 {
+		fields[2] = strconv.Itoa(g.GID)
+	}
+	for _, member := range g.Members {
+		addMember(fields, member)
+	}
+	group.set(fields)
+	return nil
+}
+
+func setUser(passwd, group, shadow *db, u config.User) error {
+	if u.Name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	if err := checkFields("user "+u.Name, map[string]string{
+		"name":            u.Name,
+		"gecos":           u.Gecos,
+		"home":            u.Home,
+		"shell":           u.Shell,
+		"hashed_password": u.HashedPassword,
+	}); err != nil {
+		return err
+	}
+
+	fields := passwd.find(u.Name)
+	if fields == nil {
+		uid := u.UID
+		if uid == 0 {
+			uid = passwd.nextID(2, firstID)
+		}
+		fields = []string{u.Name, "x", strconv.Itoa(uid), "", "", path.Join("/home", u.Name), defaultShell}
+	} else if u.UID != 0 {
+		fields[2] = strconv.Itoa(u.UID)
+	}
+
+	switch {
+	case u.GID != 0:
+		fields[3] = strconv.Itoa(u.GID)
+	case fields[3] == "":
+		// a new user gets a group of its own, with the same ID if it is free
+		g := group.find(u.Name)
+		if g == nil {
+			gid := fields[2]
+			if group.findID(2, gid) != nil {
+				gid = strconv.Itoa(group.nextID(2, firstID))
+			}
+			g = []string{u.Name, "x", gid, ""}
+			group.set(g)
+		}
+		fields[3] = g[2]
+	}
+	if u.Gecos != "" {
+		fields[4] = u.Gecos
+	}
+	if u.Home != "" {
+		fields[5] = u.Home
+	}
+	if u.Shell != "" {
+		fields[6] = u.Shell
+	}
+	passwd.set(fields)
+
+	for _, name := range u.Groups {
+		g := group.find(name)
+		if g == nil {
+			return fmt.Errorf("user %s: group %s does not exist", u.Name, name)
+		}
+		addMember(g, u.Name)
+		group.set(g)
+	}
+
+	s := shadow.find(u.Name)
+	if s == nil {
+		// no password, but not locked either so SSH keys work
+		s = []string{u.Name, "*", strconv.FormatInt(time.Now().Unix()/86400, 10), "0", "99999", "7", "", "", ""}
+	}
+	if u.HashedPassword != "" {
+		s[1] = u.HashedPassword
+	}
+	if u.LockPassword && !strings.HasPrefix(s[1], "!") {
+		s[1] = "!" + s[1]
+	} else if !u.LockPassword && u.HashedPassword != "" {
+		s[1] = strings.TrimPrefix(s[1], "!")
+	}
+	shadow.set(s)
+	return nil
+}
+
+// checkFields returns an error if a value would break the lines of /etc/passwd, /etc/group or /etc/shadow, where the
+// fields are separated by colons
+func checkFields(what string, fields map[string]string) error {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.ContainsAny(fields[name], ":\n\r") {
+			return fmt.Errorf("%s: %s %q must not contain a colon or a line break", what, name, fields[name])
+		}
+	}
+	return nil
+}
+
+func addMember(group []string, user string) {
+	members := []string{}
+	if group[3] != "" {
+		members = strings.Split(group[3], ",")
+	}
+	for _, m := range members {
+		if m == user {
+			return
+		}
+	}
+	group[3] = strings.Join(append(members, user), ",")
+}
+
+// writeSudoers writes the sudo rules of the users, config.DefaultUser may use sudo without a password unless its rules
+// are configured
+func writeSudoers(t *target.Target, cfg *config.CloudConfig) error {
+	rules := map[string][]string{
+		config.DefaultUser: defaultSudo,
+	}
+	for _, u := range cfg.Users {
+		if len(u.Sudo) > 0 {
+			rules[u.Name] = u.Sudo
+		}
+	}
+
+	var names []string
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := &strings.Builder{}
+	for _, name := range names {
+		for _, rule := range rules[name] {
+			if strings.ContainsAny(rule, "\n\r") {
+				return fmt.Errorf("user %s: sudo rule %q spans more than one line", name, rule)
+			}
+			fmt.Fprintf(buf, "%s %s\n", name, rule)
+		}
+	}
+
+	if err := t.MkdirAll(path.Dir(SudoersFile), 0750); err != nil {
+		return err
+	}
+	content := []byte(buf.String())
+	if old, err := t.ReadFile(SudoersFile); err == nil && bytes.Equal(old, content) {
+		return nil
+	}
+	if err := checkSudoers(t, content); err != nil {
+		return err
+	}
+	return t.WriteFileAtomic(SudoersFile, content, 0440)
+}
+
+// checkSudoers has visudo check the rules before they are installed, sudo refuses to run at all with a broken file.
+// sudo ignores the files of /etc/sudoers.d with a dot in their name, so the rules are checked there.
+func checkSudoers(t *target.Target, content []byte) error {
+	if t.DryRun {
+		return nil
+	}
+	if _, err := t.Stat(visudo); err != nil {
+		return nil
+	}
+	tmp := path.Join(path.Dir(SudoersFile), ".users.new")
+	if err := ioutil.WriteFile(t.Path(tmp), content, 0440); err != nil {
+		return err
+	}
+	defer os.Remove(t.Path(tmp))
+	out := &bytes.Buffer{}
+	cmd := exec.Command(visudo, "-cf", tmp)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := t.Run(cmd); err != nil {
+		return fmt.Errorf("invalid sudo rules: %v: %s", err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
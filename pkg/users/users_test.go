@@ -0,0 +1,143 @@
+package users
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestConfigureUsers(t *testing.T) {
+	root, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"etc/passwd": "root:x:0:0:root:/root:/bin/ash\nrancher:x:1000:1000::/home/rancher:/bin/bash\n",
+		"etc/group":  "root:x:0:root\nwheel:x:10:root\nrancher:x:1000:\n",
+		"etc/shadow": "root:*:0:0:99999:7:::\nrancher:*:18000:0:99999:7:::\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.CloudConfig{
+		Groups: []config.Group{
+			{Name: "docker", Members: []string{"rancher"}},
+		},
+		Users: []config.User{
+			{
+				Name:           "ops",
+				Groups:         []string{"wheel", "docker"},
+				Shell:          "/bin/ash",
+				HashedPassword: "$6$salt$hash",
+				LockPassword:   true,
+				Sudo:           []string{"ALL=(ALL) ALL"},
+			},
+			{Name: "rancher", Gecos: "k3OS"},
+		},
+	}
+
+	// the owner of new home directories can not be changed without being root
+	tgt := target.New(root, true)
+	tgt.Out = ioutil.Discard
+	if err := ConfigureUsers(tgt, cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	tgt.DryRun = false
+	if err := os.MkdirAll(filepath.Join(root, "home/ops"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfigureUsers(tgt, cfg, false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"etc/passwd": "root:x:0:0:root:/root:/bin/ash\nrancher:x:1000:1000:k3OS:/home/rancher:/bin/bash\n" +
+			"ops:x:1001:1002::/home/ops:/bin/ash\n",
+		"etc/group":           "root:x:0:root\nwheel:x:10:root,ops\nrancher:x:1000:\ndocker:x:1001:rancher,ops\nops:x:1002:\n",
+		"etc/sudoers.d/users": "ops ALL=(ALL) ALL\nrancher ALL = (ALL) NOPASSWD: ALL\n",
+	}
+	for name, content := range expected {
+		bytes, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bytes) != content {
+			t.Errorf("%s: got:\n%s\nexpected:\n%s", name, bytes, content)
+		}
+	}
+
+	shadow, err := ioutil.ReadFile(filepath.Join(root, "etc/shadow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(shadow), "\nops:!$6$salt$hash:") {
+		t.Errorf("expected a locked password for ops:\n%s", shadow)
+	}
+}
+
+func TestConfigureUsersFailure(t *testing.T) {
+	root, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.CloudConfig{
+		Users: []config.User{{Name: "ops", Groups: []string{"missing"}}},
+	}
+	if err := ConfigureUsers(target.New(root, false), cfg, false); err == nil {
+		t.Fatal("expected an error for a missing group")
+	}
+	// the default rule is written anyway
+	sudoers, err := ioutil.ReadFile(filepath.Join(root, SudoersFile))
+	if err != nil || string(sudoers) != "rancher ALL = (ALL) NOPASSWD: ALL\n" {
+		t.Errorf("unexpected %s: %q, %v", SudoersFile, sudoers, err)
+	}
+}
+
+func TestConfigureUsersInvalidFields(t *testing.T) {
+	root, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/ash\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cfg := range []*config.CloudConfig{
+		{Users: []config.User{{Name: "ops", Gecos: "Ops: team"}}},
+		{Users: []config.User{{Name: "ops", Shell: "/bin/sh\nevil::0:0::/:/bin/sh"}}},
+		{Users: []config.User{{Name: "ops", HashedPassword: "$6$salt$hash\nroot::0"}}},
+		{Users: []config.User{{Name: "ops:x"}}},
+		{Groups: []config.Group{{Name: "docker", Members: []string{"a,b"}}}},
+		{Groups: []config.Group{{Name: "docker\nwheel"}}},
+	} {
+		if err := ConfigureUsers(target.New(root, false), cfg, false); err == nil {
+			t.Errorf("expected an error for %+v %+v", cfg.Users, cfg.Groups)
+		}
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(root, "etc/passwd")); err != nil || string(content) != passwd {
+		t.Errorf("/etc/passwd was changed:\n%s", content)
+	}
+}
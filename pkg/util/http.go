@@ -0,0 +1,69 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	caCertsLock sync.Mutex
+	caCerts     []byte
+)
+
+// SetCACerts adds the PEM certificates to those HTTPClient trusts.  The system bundle is only read once per
+// process, so certificates added to it later are not trusted otherwise.
+func SetCACerts(pem []byte) {
+	caCertsLock.Lock()
+	defer caCertsLock.Unlock()
+	caCerts = pem
+}
+
+// HTTPClient returns the client of every download of k3os, it goes through the proxy of k3os.proxy and trusts the
+// certificates of caCerts
+func HTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyURL
+
+	caCertsLock.Lock()
+	defer caCertsLock.Unlock()
+	if len(caCerts) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			logrus.Warnf("failed to read the system certificates: %v", err)
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(caCerts)
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// ParseCertificates parses the PEM certificates, there must be at least one and nothing but certificates
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("expected a CERTIFICATE, got a %s", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	return certs, nil
+}
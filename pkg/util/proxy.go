@@ -0,0 +1,147 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EnvironmentFile holds the environment of every login and service, including the proxy of k3os.proxy
+const EnvironmentFile = "/etc/environment"
+
+// Proxy is the HTTP proxy used by HTTPClient
+type Proxy struct {
+	HTTP    string
+	HTTPS   string
+	NoProxy []string
+}
+
+var (
+	proxyLock sync.Mutex
+	proxy     *Proxy
+)
+
+// SetProxy sets the proxy of HTTPClient, without it the proxy of the environment or of EnvironmentFile is used
+func SetProxy(p Proxy) {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	proxy = &p
+}
+
+// ResetProxy undoes SetProxy, HTTPClient uses the proxy of the environment or of EnvironmentFile again
+func ResetProxy() {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	proxy = nil
+}
+
+// ParseEnvironment parses the KEY=value lines of an environment file, the values may be quoted and the lines may
+// start with export
+func ParseEnvironment(data []byte) map[string]string {
+	env := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export")
+		line = strings.TrimSpace(line)
+		if len(line) > 1 {
+			parts := strings.SplitN(line, "=", 2)
+			key := parts[0]
+			val := ""
+			if len(parts) > 1 {
+				var err error
+				if val, err = strconv.Unquote(parts[1]); err != nil {
+					val = parts[1]
+				}
+			}
+			env[key] = val
+		}
+	}
+	return env
+}
+
+func currentProxy() Proxy {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	if proxy != nil {
+		return *proxy
+	}
+
+	env := map[string]string{}
+	for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		if val, ok := os.LookupEnv(key); ok {
+			env[key] = val
+		} else if val, ok := os.LookupEnv(strings.ToLower(key)); ok {
+			env[key] = val
+		}
+	}
+	if len(env) == 0 {
+		// services do not get the environment of logins, but the proxy is the same
+		if data, err := ioutil.ReadFile(EnvironmentFile); err == nil {
+			env = ParseEnvironment(data)
+		}
+	}
+	p := Proxy{
+		HTTP:  env["HTTP_PROXY"],
+		HTTPS: env["HTTPS_PROXY"],
+	}
+	if env["NO_PROXY"] != "" {
+		p.NoProxy = strings.Split(env["NO_PROXY"], ",")
+	}
+	return p
+}
+
+func proxyURL(req *http.Request) (*url.URL, error) {
+	p := currentProxy()
+	proxy := p.HTTP
+	if req.URL.Scheme == "https" {
+		proxy = p.HTTPS
+	}
+	if proxy == "" || !useProxy(p.NoProxy, req.URL.Hostname()) {
+		return nil, nil
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	return url.Parse(proxy)
+}
+
+// useProxy returns false for local hosts and the hosts matching noProxy, which are host names that also match their
+// subdomains, IP addresses and CIDRs, or * for every host
+func useProxy(noProxy []string, host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return false
+	}
+	for _, pattern := range noProxy {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if pattern == "*" {
+			return false
+		}
+		if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return false
+			}
+			continue
+		}
+		pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, "*"), ".")
+		if host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return false
+		}
+	}
+	return true
+}
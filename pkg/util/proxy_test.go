@@ -0,0 +1,62 @@
+package util
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUseProxy(t *testing.T) {
+	noProxy := []string{"10.42.0.0/16", ".internal", "example.com", "192.168.1.1"}
+	for host, expected := range map[string]bool{
+		"github.com":           true,
+		"localhost":            false,
+		"127.0.0.1":            false,
+		"10.42.3.4":            false,
+		"10.43.0.1":            true,
+		"registry.internal":    false,
+		"internal":             false,
+		"example.com":          false,
+		"www.example.com":      false,
+		"notexample.com":       true,
+		"192.168.1.1":          false,
+		"192.168.1.2":          true,
+		"REGISTRY.Internal":    false,
+		"metadata.google.com":  true,
+		"169.254.169.254":      true,
+		"example.com.attacker": true,
+	} {
+		if got := useProxy(noProxy, host); got != expected {
+			t.Errorf("%s: expected %v, got %v", host, expected, got)
+		}
+	}
+	if useProxy([]string{"*"}, "github.com") {
+		t.Error("expected * to match every host")
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	SetProxy(Proxy{HTTP: "proxy.local:3128", HTTPS: "https://secure.local:3129", NoProxy: []string{"example.com"}})
+	defer func() { proxy = nil }()
+
+	for u, expected := range map[string]string{
+		"http://github.com/keys":  "http://proxy.local:3128",
+		"https://github.com/keys": "https://secure.local:3129",
+		"https://example.com/":    "",
+	} {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy, err := proxyURL(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if proxy != nil {
+			got = proxy.String()
+		}
+		if got != expected {
+			t.Errorf("%s: expected proxy %q, got %q", u, expected, got)
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
 func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
@@ -25,11 +31,68 @@ func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
 	if err := os.Chmod(tempFile.Name(), perm); err != nil {
 		return err
 	}
+	if err := keepOwner(tempFile.Name(), filename); err != nil {
+		return err
+	}
 	return os.Rename(tempFile.Name(), filename)
 }
 
+// keepOwner gives the file the owner of the file it replaces, if that exists
+func keepOwner(filename, replaced string) error {
+	info, err := os.Stat(replaced)
+	if err != nil {
+		return nil
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(st.Uid) == os.Getuid() && int(st.Gid) == os.Getgid() {
+		return nil
+	}
+	return os.Chown(filename, int(st.Uid), int(st.Gid))
+}
+
+// SHA256File returns the hex encoded sha256 checksum of the content of the file
+func SHA256File(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CopyFileAtomic copies src to dst through a temporary file, so dst is replaced atomically
+func CopyFileAtomic(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dir, file := path.Split(dst)
+	tempFile, err := ioutil.TempFile(dir, fmt.Sprintf(".%s", file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := io.Copy(tempFile, in); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempFile.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), dst)
+}
+
 func HTTPDownloadToFile(url, dest string) error {
-	res, err := http.Get(url)
+	res, err := HTTPClient().Get(url)
 	if err != nil {
 		return err
 	}
@@ -43,7 +106,7 @@ func HTTPDownloadToFile(url, dest string) error {
 
 func HTTPLoadBytes(url string) ([]byte, error) {
 	var resp *http.Response
-	resp, err := http.Get(url)
+	resp, err := HTTPClient().Get(url)
 	if err == nil {
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
@@ -111,3 +174,18 @@ func EnsureDirectoryExists(dir string) error {
 	}
 	return nil
 }
+
+// ParseSize parses a size in bytes with an optional binary unit, such as 512M or 2GiB
+func ParseSize(s string) (int64, error) {
+	units := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	unit := ""
+	if num != "" && strings.Contains("KMGT", num[len(num)-1:]) {
+		num, unit = num[:len(num)-1], num[len(num)-1:]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a size such as 512M or 2G", s)
+	}
+	return n * units[unit], nil
+}
@@ -6,44 +6,70 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
 	"github.com/rancher/k3os/pkg/util"
 	"github.com/sirupsen/logrus"
 )
 
-func WriteFiles(cfg *config.CloudConfig) {
+func WriteFiles(t *target.Target, cfg *config.CloudConfig) error {
+	failed := 0
 	for i, f := range cfg.WriteFiles {
 		c, err := util.DecodeContent(f.Content, f.Encoding)
 		if err != nil {
 			logrus.Errorf("failed to decode content from write_files item [%d]: %v", i, err)
+			failed++
 			continue
 		}
 		f.Content = string(c)
 		f.Encoding = ""
-		p, err := WriteFile(&f, "/")
+		p, err := WriteFile(t, &f)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{"err": err, "path": p}).Errorln("failed to write file")
+			failed++
 			continue
 		}
-		logrus.Infof("wrote file %s to filesystem", p)
+		if !t.DryRun {
+			logrus.Infof("wrote file %s to filesystem", p)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to write %d of %d files", failed, len(cfg.WriteFiles))
 	}
+	return nil
 }
 
-func WriteFile(f *config.File, root string) (string, error) {
+func WriteFile(t *target.Target, f *config.File) (string, error) {
 	if f.Encoding != "" {
 		return "", fmt.Errorf("unable to write file with encoding %s", f.Encoding)
 	}
-	p := path.Join(root, f.Path)
+	p := t.Path(f.Path)
+	perm, err := f.Permissions()
+	if err != nil {
+		return "", err
+	}
+	if unchanged(t, f, perm) {
+		return p, nil
+	}
+	if t.DryRun {
+		t.Secret(f.Path)
+		if err := t.WriteFile(f.Path, []byte(f.Content), perm); err != nil {
+			return "", err
+		}
+		if f.Owner != "" {
+			err = t.Run(exec.Command("chown", f.Owner, f.Path))
+		}
+		return p, err
+	}
 	d := path.Dir(p)
 	logrus.Infof("writing file to %q", d)
 	if err := util.EnsureDirectoryExists(d); err != nil {
 		return "", err
 	}
-	perm, err := f.Permissions()
-	if err != nil {
-		return "", err
-	}
 	var tmp *os.File
 	// create a temporary file in the same directory to ensure it's on the same filesystem
 	if tmp, err = ioutil.TempFile(d, "wfs-temp"); err != nil {
@@ -60,14 +86,76 @@ func WriteFile(f *config.File, root string) (string, error) {
 		return "", err
 	}
 	if f.Owner != "" {
-		// we shell out since we don't have a way to look up unix groups natively
-		cmd := exec.Command("chown", f.Owner, tmp.Name())
-		if err := cmd.Run(); err != nil {
+		// we shell out since we don't have a way to look up unix groups natively, in the target so its users are used
+		cmd := exec.Command("chown", f.Owner, path.Join(path.Dir(f.Path), path.Base(tmp.Name())))
+		if err := t.Run(cmd); err != nil {
 			return "", err
 		}
 	}
 	if err := os.Rename(tmp.Name(), p); err != nil {
 		return "", err
 	}
+	t.Changed("wrote %s", f.Path)
 	return p, nil
 }
+
+// unchanged returns true if the file already has the content, the permissions and the owner.  If the owner can not
+// be looked up the file is written, so chown decides.
+func unchanged(t *target.Target, f *config.File, perm os.FileMode) bool {
+	info, err := t.Stat(f.Path)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm() != perm.Perm() ||
+		info.Size() != int64(len(f.Content)) {
+		return false
+	}
+	if f.Owner != "" {
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return false
+		}
+		uid, gid, found := lookupOwner(t, f.Owner, int(st.Gid))
+		if !found || int(st.Uid) != uid || int(st.Gid) != gid {
+			return false
+		}
+	}
+	content, err := t.ReadFile(f.Path)
+	return err == nil && string(content) == f.Content
+}
+
+// lookupOwner returns the IDs of the user[:group] owner, the user and group names are looked up in the /etc/passwd
+// and /etc/group of the target.  Without a group chown keeps the current group gid.
+func lookupOwner(t *target.Target, owner string, gid int) (int, int, bool) {
+	parts := strings.SplitN(owner, ":", 2)
+	if len(parts) == 1 {
+		parts = strings.SplitN(owner, ".", 2)
+	}
+	uid, ok := lookupID(t, "/etc/passwd", parts[0])
+	if !ok {
+		return 0, 0, false
+	}
+	if len(parts) == 2 {
+		// user: is the login group of the user, left to chown
+		if gid, ok = lookupID(t, "/etc/group", parts[1]); !ok || parts[1] == "" {
+			return 0, 0, false
+		}
+	}
+	return uid, gid, true
+}
+
+// lookupID returns the ID of the name in the passwd or group file, a number is taken as the ID itself
+func lookupID(t *target.Target, file, name string) (int, bool) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, true
+	}
+	content, err := t.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 2 && fields[0] == name {
+			id, err := strconv.Atoi(fields[2])
+			return id, err == nil
+		}
+	}
+	return 0, false
+}
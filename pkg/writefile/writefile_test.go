@@ -0,0 +1,57 @@
+package writefile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/k3os/pkg/config"
+	"github.com/rancher/k3os/pkg/target"
+)
+
+func TestWriteFileUnchanged(t *testing.T) {
+	if _, err := exec.LookPath("chown"); err != nil {
+		t.Skip("chown is not installed")
+	}
+	root, err := ioutil.TempDir("", "writefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// chown runs chrooted in a target that is not the running system, which needs root
+	tgt := target.New("/", false)
+	f := &config.File{
+		Path:               filepath.Join(root, "motd"),
+		Content:            "welcome\n",
+		Owner:              fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		RawFilePermissions: "0600",
+	}
+	if _, err := WriteFile(tgt, f); err != nil {
+		t.Fatal(err)
+	}
+	if len(tgt.Changes()) != 2 {
+		t.Fatalf("expected the file to be written, got %v", tgt.Changes())
+	}
+
+	changes := len(tgt.Changes())
+	if _, err := WriteFile(tgt, f); err != nil {
+		t.Fatal(err)
+	}
+	if len(tgt.Changes()) != changes {
+		t.Errorf("expected the unchanged file to be left alone, got %v", tgt.Changes()[changes:])
+	}
+
+	if err := os.Chmod(f.Path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteFile(tgt, f); err != nil {
+		t.Fatal(err)
+	}
+	if len(tgt.Changes()) == changes {
+		t.Error("expected the file to be written again with other permissions")
+	}
+}